@@ -0,0 +1,39 @@
+// jmx-exporter-attach loads a java agent into a running HotSpot JVM without relying on a JDK. The operator
+// copies it into the containers along with the prometheus jmx exporter jars.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/attach"
+)
+
+func main() {
+	timeout := flag.Duration("timeout", attach.DefaultTimeout, "Time to wait for the JVM to respond")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-timeout duration] <pid> <agent jar> [agent options]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 || flag.NArg() > 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	pid, err := strconv.Atoi(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid pid '%s'\n", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	if err := attach.LoadAgent(pid, flag.Arg(1), flag.Arg(2), *timeout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Agent %s loaded into process %d\n", flag.Arg(1), pid)
+}
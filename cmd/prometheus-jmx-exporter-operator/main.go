@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
+	"flag"
 	"runtime"
 
 	stub "github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/stub"
-	sdk "github.com/operator-framework/operator-sdk/pkg/sdk"
 	sdkVersion "github.com/operator-framework/operator-sdk/version"
 
 	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func printVersion() {
@@ -19,11 +23,114 @@ func printVersion() {
 }
 
 func main() {
+	kubeconfig := flag.String("kubeconfig", "", "Path to the kubeconfig file, if not set KUBECONFIG or the in-cluster config is used")
+	kubeContext := flag.String("context", "", "The context of the kubeconfig file to use, defaults to the current context")
+	flag.Parse()
+
 	printVersion()
+
+	config, err := stub.NewKubeConfig(*kubeconfig, *kubeContext)
+	if err != nil {
+		logrus.Fatalf("Loading Kubernetes client config failed: %v", err)
+	}
+	if err := stub.InitClients(config); err != nil {
+		logrus.Fatalf("Creating Kubernetes clients failed: %v", err)
+	}
+	logrus.Infof("Connecting to Kubernetes API server at %s", config.Host)
+
 	namespace := os.Getenv("OPERATOR_NAMESPACE")
 
-	sdk.Watch("banzaicloud.com/v1alpha1", "PrometheusJmxExporter", namespace, 0)
-	sdk.Watch("v1", "Pod", namespace, 0)
-	sdk.Handle(stub.NewHandler())
-	sdk.Run(context.TODO())
+	// WATCH_NAMESPACES is either '*' to watch all namespaces or a comma separated list of namespaces.
+	// If it's not set only the namespace of the operator is watched.
+	var namespaces []string
+	watchNamespaces, clusterMode := os.LookupEnv("WATCH_NAMESPACES")
+	if clusterMode {
+		if watchNamespaces == "*" {
+			namespaces = []string{metav1.NamespaceAll}
+		} else {
+			for _, ns := range strings.Split(watchNamespaces, ",") {
+				if ns = strings.TrimSpace(ns); len(ns) > 0 {
+					namespaces = append(namespaces, ns)
+				}
+			}
+		}
+	} else {
+		namespaces = []string{namespace}
+	}
+
+	if len(namespaces) == 1 && namespaces[0] == metav1.NamespaceAll {
+		stub.WatchNamespaces(nil, clusterMode)
+	} else {
+		stub.WatchNamespaces(namespaces, clusterMode)
+	}
+
+	logrus.Infof("Watching namespaces: %v, cluster scoped exporters enabled: %t", namespaces, clusterMode)
+
+	executor, err := stub.NewSPDYExecutor(config)
+	if err != nil {
+		logrus.Fatalf("Creating pod executor failed: %v", err)
+	}
+	handler := stub.NewHandler(executor)
+
+	ctx := context.TODO()
+
+	// the webhooks read exporters from the informer caches thus these must be synced before serving requests
+	if err := stub.RunInformers(ctx.Done()); err != nil {
+		logrus.Fatalf("Starting informers failed: %v", err)
+	}
+
+	podWorkers, err := strconv.Atoi(getEnv("POD_WORKERS", "4"))
+	if err != nil || podWorkers < 1 {
+		logrus.Fatalf("Invalid POD_WORKERS value: '%s'", os.Getenv("POD_WORKERS"))
+	}
+	handler.RunPodWorkers(podWorkers, ctx.Done())
+
+	// PROBE_INTERVAL set to 0 disables probing the prometheus jmx exporter endpoints
+	probeInterval, err := time.ParseDuration(getEnv("PROBE_INTERVAL", "30s"))
+	if err != nil || probeInterval < 0 {
+		logrus.Fatalf("Invalid PROBE_INTERVAL value: '%s'", os.Getenv("PROBE_INTERVAL"))
+	}
+	if probeInterval > 0 {
+		handler.RunProber(probeInterval, ctx.Done())
+	}
+
+	if certFile := os.Getenv("WEBHOOK_TLS_CERT_FILE"); len(certFile) > 0 {
+		webhookServer := stub.NewWebhookServer(
+			getEnv("WEBHOOK_LISTEN_ADDRESS", ":8443"),
+			certFile,
+			os.Getenv("WEBHOOK_TLS_KEY_FILE"),
+			os.Getenv("OPERATOR_IMAGE"))
+
+		go func() {
+			if err := webhookServer.Run(); err != nil {
+				logrus.Fatalf("Webhook server failed: %v", err)
+			}
+		}()
+	}
+
+	if clusterMode {
+		watch("banzaicloud.com/v1alpha1", "ClusterPrometheusJmxExporter", metav1.NamespaceAll)
+	}
+	for _, ns := range namespaces {
+		watch("banzaicloud.com/v1alpha1", "PrometheusJmxExporter", ns)
+		watch("v1", "Pod", ns)
+		watch("v1", "ConfigMap", ns)
+	}
+	stub.Run(ctx, handler)
+}
+
+// watch sends the events of the resources identified by apiVersion and kind in namespace to the handler
+func watch(apiVersion, kind, namespace string) {
+	if err := stub.WatchResource(apiVersion, kind, namespace, 0); err != nil {
+		logrus.Fatalf("Watching %s in namespace '%s' failed: %v", kind, namespace, err)
+	}
+}
+
+// getEnv returns the value of the environment variable named by key or defaultValue if it's not set
+func getEnv(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return defaultValue
 }
@@ -29,6 +29,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&PrometheusJmxExporter{},
 		&PrometheusJmxExporterList{},
+		&ClusterPrometheusJmxExporter{},
+		&ClusterPrometheusJmxExporterList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
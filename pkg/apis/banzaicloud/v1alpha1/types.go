@@ -2,7 +2,9 @@ package v1alpha1
 
 import (
 	"fmt"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -22,63 +24,388 @@ type PrometheusJmxExporter struct {
 	Status            PrometheusJmxExporterStatus `json:"status,omitempty"`
 }
 
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type ClusterPrometheusJmxExporterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+	Items           []ClusterPrometheusJmxExporter `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPrometheusJmxExporter is the cluster scoped variant of PrometheusJmxExporter which selects
+// the pods of all namespaces matching its namespace selector
+type ClusterPrometheusJmxExporter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              ClusterPrometheusJmxExporterSpec `json:"spec"`
+	Status            PrometheusJmxExporterStatus      `json:"status,omitempty"`
+}
+
+type ClusterPrometheusJmxExporterSpec struct {
+	// NamespaceSelector selects the namespaces of the pods, if not set the pods of all namespaces
+	// managed by the operator are selected
+	NamespaceSelector         *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PrometheusJmxExporterSpec `json:",inline"`
+}
+
+const (
+	PrometheusJmxExporterKind        = "PrometheusJmxExporter"
+	ClusterPrometheusJmxExporterKind = "ClusterPrometheusJmxExporter"
+)
+
+// ForNamespace returns the PrometheusJmxExporter equivalent of the ClusterPrometheusJmxExporter in namespace.
+// The returned object is not stored in the API server, it's controlled by the ClusterPrometheusJmxExporter
+// which holds its status.
+func (exporter *ClusterPrometheusJmxExporter) ForNamespace(namespace string) *PrometheusJmxExporter {
+	controller := true
+
+	return &PrometheusJmxExporter{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       PrometheusJmxExporterKind,
+			APIVersion: SchemeGroupVersion.String(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              exporter.Name,
+			Namespace:         namespace,
+			UID:               exporter.UID,
+			Generation:        exporter.Generation,
+			DeletionTimestamp: exporter.DeletionTimestamp,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: SchemeGroupVersion.String(),
+					Kind:       ClusterPrometheusJmxExporterKind,
+					Name:       exporter.Name,
+					UID:        exporter.UID,
+					Controller: &controller,
+				},
+			},
+		},
+		Spec:   *exporter.Spec.PrometheusJmxExporterSpec.DeepCopy(),
+		Status: *exporter.Status.DeepCopy(),
+	}
+}
+
+// ClusterOwner returns the reference to the ClusterPrometheusJmxExporter the PrometheusJmxExporter
+// was derived from by ForNamespace, nil if it's a stored PrometheusJmxExporter
+func (exporter *PrometheusJmxExporter) ClusterOwner() *metav1.OwnerReference {
+	for i := range exporter.OwnerReferences {
+		ownerReference := &exporter.OwnerReferences[i]
+		if ownerReference.Kind == ClusterPrometheusJmxExporterKind && ownerReference.Controller != nil && *ownerReference.Controller {
+			return ownerReference
+		}
+	}
+
+	return nil
+}
+
+const (
+	// InjectionModeAttach loads the prometheus jmx exporter agent into the already running java processes
+	InjectionModeAttach = "attach"
+	// InjectionModeStartup adds the prometheus jmx exporter agent to the java processes
+	// through -javaagent when the pod is created
+	InjectionModeStartup = "startup"
+)
+
 type PrometheusJmxExporterSpec struct {
-	LabelSelector map[string]string `json:"labelSelector,required"`
-	Config        struct {
-		ConfigMapName string `json:"configMapName,required"`
-		ConfigMapKey  string `json:"configMapKey,required"`
-	} `json:"config"`
-	Port int `json: port,required`
+	// LabelSelector selects the pods by equality of their labels, kept for backward compatibility, use Selector instead
+	LabelSelector map[string]string `json:"labelSelector,omitempty"`
+	// Selector selects the pods by matchLabels and matchExpressions, mutually exclusive with LabelSelector
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	Config   ConfigSource          `json:"config"`
+	Port     int                   `json: port,required`
+	// PortRange if set the operator picks a free port from this range for each pod instead of Port
+	PortRange *PortRange `json:"portRange,omitempty"`
+	// Mode is either InjectionModeAttach (default) or InjectionModeStartup
+	Mode string `json:"mode,omitempty"`
+	// ContainerSelector selects the containers of the pods to load the agent into, if not set the first container is selected
+	ContainerSelector *ContainerSelector `json:"containerSelector,omitempty"`
+	// ProcessSelector selects the java processes of the containers to load the agent into, required
+	// if there are multiple java processes running in a container
+	ProcessSelector *ProcessSelector `json:"processSelector,omitempty"`
+	// Monitor if set the operator creates a PodMonitor or a Service and a ServiceMonitor for the Prometheus Operator
+	Monitor *MonitorSpec `json:"monitor,omitempty"`
+	// RetryPolicy if set the pods the agent failed to be loaded into are retried, otherwise they are given up at the first failure
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+// RetryPolicy describes how the pods the prometheus jmx exporter agent failed to be loaded into are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one before giving up a pod, defaults to 3
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff is the delay before the first retry which is doubled after each failed attempt, e.g. 30s, defaults to 30s
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff caps the delay between attempts, e.g. 10m, defaults to 10m
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+}
+
+const (
+	// MonitorKindPodMonitor scrapes the pods through a PodMonitor
+	MonitorKindPodMonitor = "PodMonitor"
+	// MonitorKindServiceMonitor scrapes the pods through a Service and a ServiceMonitor
+	MonitorKindServiceMonitor = "ServiceMonitor"
+)
+
+// MonitorSpec describes the Prometheus Operator resources scraping the prometheus jmx exporter endpoints
+type MonitorSpec struct {
+	// Kind is either MonitorKindPodMonitor (default) or MonitorKindServiceMonitor
+	Kind string `json:"kind,omitempty"`
+	// Interval at which metrics are scraped, e.g. 30s
+	Interval string `json:"interval,omitempty"`
+	// ScrapeTimeout of the scrape requests, e.g. 10s
+	ScrapeTimeout string `json:"scrapeTimeout,omitempty"`
+	// Labels are added to the created resources, they can be used to match the monitor selector of Prometheus
+	Labels map[string]string `json:"labels,omitempty"`
+	// Relabelings are applied to the scraped targets before ingestion
+	Relabelings []RelabelConfig `json:"relabelings,omitempty"`
+}
+
+// RelabelConfig is a Prometheus relabel config
+type RelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty"`
+}
+
+// PodSelector returns the label selector of the pods, either Selector or the equivalent of LabelSelector
+func (spec *PrometheusJmxExporterSpec) PodSelector() *metav1.LabelSelector {
+	if spec.Selector != nil {
+		return spec.Selector
+	}
+
+	return &metav1.LabelSelector{MatchLabels: spec.LabelSelector}
+}
+
+// MonitorKind returns the kind of the Prometheus Operator monitor resource, empty if no monitor is requested
+func (spec *PrometheusJmxExporterSpec) MonitorKind() string {
+	if spec.Monitor == nil {
+		return ""
+	}
+	if len(spec.Monitor.Kind) == 0 {
+		return MonitorKindPodMonitor
+	}
+
+	return spec.Monitor.Kind
+}
+
+// ConfigSource is either a reference to a key of a ConfigMap holding the prometheus jmx exporter config
+// or the config itself given inline
+type ConfigSource struct {
+	ConfigMapName string `json:"configMapName,omitempty"`
+	ConfigMapKey  string `json:"configMapKey,omitempty"`
+	// ConfigMapNamespace is the namespace of the ConfigMap, defaults to the namespace of the PrometheusJmxExporter.
+	// Required by ClusterPrometheusJmxExporters referencing a ConfigMap.
+	ConfigMapNamespace string `json:"configMapNamespace,omitempty"`
+	// Inline is the prometheus jmx exporter config, mutually exclusive with ConfigMapName
+	Inline *PrometheusJmxExporterConfig `json:"inline,omitempty"`
+}
+
+// Validate returns an error unless exactly one of the ConfigMap reference and the inline config is set
+func (source *ConfigSource) Validate() error {
+	if source.Inline != nil {
+		if len(source.ConfigMapName) > 0 || len(source.ConfigMapKey) > 0 || len(source.ConfigMapNamespace) > 0 {
+			return fmt.Errorf("config.inline and config.configMapName are mutually exclusive")
+		}
+		return nil
+	}
+
+	if len(source.ConfigMapName) == 0 {
+		return fmt.Errorf("either config.configMapName or config.inline must be set")
+	}
+	if len(source.ConfigMapKey) == 0 {
+		return fmt.Errorf("config.configMapKey must be set")
+	}
+
+	return nil
+}
+
+// ProcessSelector selects java processes by MainClass and/or SystemProperty
+type ProcessSelector struct {
+	// MainClass is a regular expression the main class or jar file reported by 'jps -l' must match
+	MainClass string `json:"mainClass,omitempty"`
+	// SystemProperty is a system property in <name> or <name>=<value> format the java process must be started with
+	SystemProperty string `json:"systemProperty,omitempty"`
+}
+
+// ContainerSelector selects containers either by Name, NameRegex or all containers running java processes
+type ContainerSelector struct {
+	Name              string `json:"name,omitempty"`
+	NameRegex         string `json:"nameRegex,omitempty"`
+	AllJavaContainers bool   `json:"allJavaContainers,omitempty"`
+}
+
+// PortRange is an inclusive range of port numbers
+type PortRange struct {
+	From int `json:"from,required"`
+	To   int `json:"to,required"`
+}
+
+// IsStartupMode returns true if the prometheus jmx exporter agent is injected at pod creation
+func (spec *PrometheusJmxExporterSpec) IsStartupMode() bool {
+	return spec.Mode == InjectionModeStartup
 }
 
 type PrometheusJmxExporterConfig struct {
-	StartDelaySeconds         *int                               `yaml:"startDelaySeconds,omitempty"`
-	HostPort                  *string                            `yaml:"hostPort,omitempty"`
-	Username                  *string                            `yaml:"username,omitempty"`
-	Password                  *string                            `yaml:"password,omitempty"`
-	JmxUrl                    *string                            `yaml:"jmxUrl,omitempty"`
-	Ssl                       *bool                              `yaml:"ssl,omitempty"`
-	LowercaseOutputName       *bool                              `yaml:"lowercaseOutputName,omitempty"`
-	LowercaseOutputLabelNames *bool                              `yaml:"lowercaseOutputLabelNames,omitempty"`
-	WhitelistObjectNames      []string                           `yaml:"whitelistObjectNames,omitempty"`
-	BlacklistObjectNames      []string                           `yaml:"blacklistObjectNames,omitempty"`
-	Rules                     []PrometheusJmxExporterConfigRules `yaml:"rules,omitempty"`
+	StartDelaySeconds         *int                               `json:"startDelaySeconds,omitempty" yaml:"startDelaySeconds,omitempty"`
+	HostPort                  *string                            `json:"hostPort,omitempty" yaml:"hostPort,omitempty"`
+	Username                  *string                            `json:"username,omitempty" yaml:"username,omitempty"`
+	Password                  *string                            `json:"password,omitempty" yaml:"password,omitempty"`
+	JmxUrl                    *string                            `json:"jmxUrl,omitempty" yaml:"jmxUrl,omitempty"`
+	Ssl                       *bool                              `json:"ssl,omitempty" yaml:"ssl,omitempty"`
+	LowercaseOutputName       *bool                              `json:"lowercaseOutputName,omitempty" yaml:"lowercaseOutputName,omitempty"`
+	LowercaseOutputLabelNames *bool                              `json:"lowercaseOutputLabelNames,omitempty" yaml:"lowercaseOutputLabelNames,omitempty"`
+	WhitelistObjectNames      []string                           `json:"whitelistObjectNames,omitempty" yaml:"whitelistObjectNames,omitempty"`
+	BlacklistObjectNames      []string                           `json:"blacklistObjectNames,omitempty" yaml:"blacklistObjectNames,omitempty"`
+	Rules                     []PrometheusJmxExporterConfigRules `json:"rules,omitempty" yaml:"rules,omitempty"`
 }
 
 type PrometheusJmxExporterConfigRules struct {
-	Pattern           *string           `yaml:"pattern,omitempty"`
-	Name              *string           `yaml:"name,omitempty"`
-	Value             *string           `yaml:"value,omitempty"`
-	ValueFactor       *float32          `yaml:"valueFactor,omitempty"`
-	Labels            map[string]string `yaml:"labels,omitempty"`
-	Help              *string           `yaml:"help,omitempty"`
-	Type              *string           `yaml:"type,omitempty"`
-	AttrNameSnakeCase *bool             `yaml:"attrNameSnakeCase,omitempty"`
+	Pattern           *string           `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Name              *string           `json:"name,omitempty" yaml:"name,omitempty"`
+	Value             *string           `json:"value,omitempty" yaml:"value,omitempty"`
+	ValueFactor       *float32          `json:"valueFactor,omitempty" yaml:"valueFactor,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Help              *string           `json:"help,omitempty" yaml:"help,omitempty"`
+	Type              *string           `json:"type,omitempty" yaml:"type,omitempty"`
+	AttrNameSnakeCase *bool             `json:"attrNameSnakeCase,omitempty" yaml:"attrNameSnakeCase,omitempty"`
 }
 
 type PrometheusJmxExporterStatus struct {
 	MetricsEndpoints []*MetricsEndpoint `json: metricsEndpoints,omitempty`
+	// ObservedGeneration is the generation of the PrometheusJmxExporter the status was computed for
+	ObservedGeneration int64                            `json:"observedGeneration,omitempty"`
+	Conditions         []PrometheusJmxExporterCondition `json:"conditions,omitempty"`
+	// Pods lists the injection state of the pods selected by the PrometheusJmxExporter
+	Pods []PodStatus `json:"pods,omitempty"`
+}
+
+type PrometheusJmxExporterConditionType string
+
+const (
+	// ConditionReady is true if the agent has been loaded into all selected pods
+	ConditionReady PrometheusJmxExporterConditionType = "Ready"
+	// ConditionConfigValid is true if the prometheus jmx exporter config could be loaded
+	ConditionConfigValid PrometheusJmxExporterConditionType = "ConfigValid"
+	// ConditionConflict is true if the selected pods are selected by other PrometheusJmxExporters as well
+	ConditionConflict PrometheusJmxExporterConditionType = "Conflict"
+	// ConditionDegraded is true if loading the agent failed in some of the selected pods
+	ConditionDegraded PrometheusJmxExporterConditionType = "Degraded"
+)
+
+type PrometheusJmxExporterCondition struct {
+	Type               PrometheusJmxExporterConditionType `json:"type"`
+	Status             corev1.ConditionStatus             `json:"status"`
+	Reason             string                             `json:"reason,omitempty"`
+	Message            string                             `json:"message,omitempty"`
+	LastTransitionTime metav1.Time                        `json:"lastTransitionTime,omitempty"`
+}
+
+type PodPhase string
+
+const (
+	// PodPending the pod hasn't been processed yet or processing it failed and will be retried
+	PodPending PodPhase = "Pending"
+	// PodInjected the agent has been loaded into the pod
+	PodInjected PodPhase = "Injected"
+	// PodFailed loading the agent into the pod failed
+	PodFailed PodPhase = "Failed"
+	// PodSkipped the pod is not processed
+	PodSkipped PodPhase = "Skipped"
+)
+
+type PodStatus struct {
+	Namespace  string       `json:"namespace,omitempty"`
+	Pod        string       `json:"pod,required"`
+	Phase      PodPhase     `json:"phase,required"`
+	LastError  string       `json:"lastError,omitempty"`
+	AttachTime *metav1.Time `json:"attachTime,omitempty"`
+	// Attempts is the number of failed attempts to load the agent into the pod
+	Attempts int `json:"attempts,omitempty"`
+	// NextRetryTime is the time of the next attempt of a failed pod, not set if the pod has been given up
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// Processes lists the java processes the agent has been loaded into
+	Processes []ProcessStatus `json:"processes,omitempty"`
+}
+
+type ProcessStatus struct {
+	Container string `json:"container,omitempty"`
+	Pid       string `json:"pid,omitempty"`
 }
 
 type MetricsEndpoint struct {
-	Pod  string `json:"pod,required"`
-	Port int    `json:"port,required"`
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,required"`
+	Container string `json:"container,omitempty"`
+	Pid       string `json:"pid,omitempty"`
+	Port      int    `json:"port,required"`
+	// Health is the outcome of the last probe of the endpoint, empty if it hasn't been probed yet
+	Health EndpointHealth `json:"health,omitempty"`
+	// HealthMessage describes why the endpoint is unhealthy
+	HealthMessage string `json:"healthMessage,omitempty"`
+}
+
+type EndpointHealth string
+
+const (
+	// EndpointHealthy endpoints serve the metrics of the JVM
+	EndpointHealthy EndpointHealth = "Healthy"
+	// EndpointUnhealthy endpoints are unreachable or don't serve the metrics of the JVM
+	EndpointUnhealthy EndpointHealth = "Unhealthy"
+)
+
+// GetCondition returns the condition of the given type or nil if there is no such condition
+func (this *PrometheusJmxExporterStatus) GetCondition(conditionType PrometheusJmxExporterConditionType) *PrometheusJmxExporterCondition {
+	for i := range this.Conditions {
+		if this.Conditions[i].Type == conditionType {
+			return &this.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type. LastTransitionTime is changed only
+// if the status of the condition changes.
+func (this *PrometheusJmxExporterStatus) SetCondition(conditionType PrometheusJmxExporterConditionType, status corev1.ConditionStatus, reason, message string) {
+	condition := this.GetCondition(conditionType)
+	if condition == nil {
+		this.Conditions = append(this.Conditions, PrometheusJmxExporterCondition{Type: conditionType})
+		condition = &this.Conditions[len(this.Conditions)-1]
+	}
+
+	if condition.Status != status {
+		condition.Status = status
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Reason = reason
+	condition.Message = message
 }
 
 // equals returns true if a equals b otherwise false
 func (this PrometheusJmxExporterStatus) Equals(that PrometheusJmxExporterStatus) bool {
+	if this.ObservedGeneration != that.ObservedGeneration ||
+		!reflect.DeepEqual(this.Conditions, that.Conditions) ||
+		!reflect.DeepEqual(this.Pods, that.Pods) {
+		return false
+	}
+
 	if len(this.MetricsEndpoints) != len(that.MetricsEndpoints) {
 		return false
 	}
 
 	diff := make(map[string]int)
 	for _, x := range this.MetricsEndpoints {
-		key := fmt.Sprintf("%s:%d", x.Pod, x.Port)
+		key := fmt.Sprintf("%s/%s/%s/%s:%d %s %s", x.Namespace, x.Pod, x.Container, x.Pid, x.Port, x.Health, x.HealthMessage)
 		diff[key]++
 	}
 
 	for _, y := range that.MetricsEndpoints {
-		key := fmt.Sprintf("%s:%d", y.Pod, y.Port)
+		key := fmt.Sprintf("%s/%s/%s/%s:%d %s %s", y.Namespace, y.Pod, y.Container, y.Pid, y.Port, y.Health, y.HealthMessage)
 		if _, ok := diff[key]; !ok {
 			return false
 		}
@@ -5,9 +5,140 @@
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPrometheusJmxExporter) DeepCopyInto(out *ClusterPrometheusJmxExporter) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPrometheusJmxExporter.
+func (in *ClusterPrometheusJmxExporter) DeepCopy() *ClusterPrometheusJmxExporter {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPrometheusJmxExporter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterPrometheusJmxExporter) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPrometheusJmxExporterList) DeepCopyInto(out *ClusterPrometheusJmxExporterList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterPrometheusJmxExporter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPrometheusJmxExporterList.
+func (in *ClusterPrometheusJmxExporterList) DeepCopy() *ClusterPrometheusJmxExporterList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPrometheusJmxExporterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterPrometheusJmxExporterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	} else {
+		return nil
+	}
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPrometheusJmxExporterSpec) DeepCopyInto(out *ClusterPrometheusJmxExporterSpec) {
+	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LabelSelector)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	in.PrometheusJmxExporterSpec.DeepCopyInto(&out.PrometheusJmxExporterSpec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPrometheusJmxExporterSpec.
+func (in *ClusterPrometheusJmxExporterSpec) DeepCopy() *ClusterPrometheusJmxExporterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPrometheusJmxExporterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigSource) DeepCopyInto(out *ConfigSource) {
+	*out = *in
+	if in.Inline != nil {
+		in, out := &in.Inline, &out.Inline
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(PrometheusJmxExporterConfig)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigSource.
+func (in *ConfigSource) DeepCopy() *ConfigSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerSelector) DeepCopyInto(out *ContainerSelector) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerSelector.
+func (in *ContainerSelector) DeepCopy() *ContainerSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsEndpoint) DeepCopyInto(out *MetricsEndpoint) {
 	*out = *in
@@ -24,6 +155,121 @@ func (in *MetricsEndpoint) DeepCopy() *MetricsEndpoint {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MonitorSpec) DeepCopyInto(out *MonitorSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Relabelings != nil {
+		in, out := &in.Relabelings, &out.Relabelings
+		*out = make([]RelabelConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MonitorSpec.
+func (in *MonitorSpec) DeepCopy() *MonitorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MonitorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PodStatus) DeepCopyInto(out *PodStatus) {
+	*out = *in
+	if in.AttachTime != nil {
+		in, out := &in.AttachTime, &out.AttachTime
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = (*in).DeepCopy()
+		}
+	}
+	if in.NextRetryTime != nil {
+		in, out := &in.NextRetryTime, &out.NextRetryTime
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = (*in).DeepCopy()
+		}
+	}
+	if in.Processes != nil {
+		in, out := &in.Processes, &out.Processes
+		*out = make([]ProcessStatus, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PodStatus.
+func (in *PodStatus) DeepCopy() *PodStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PodStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortRange) DeepCopyInto(out *PortRange) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortRange.
+func (in *PortRange) DeepCopy() *PortRange {
+	if in == nil {
+		return nil
+	}
+	out := new(PortRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProcessSelector) DeepCopyInto(out *ProcessSelector) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProcessSelector.
+func (in *ProcessSelector) DeepCopy() *ProcessSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(ProcessSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProcessStatus) DeepCopyInto(out *ProcessStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProcessStatus.
+func (in *ProcessStatus) DeepCopy() *ProcessStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ProcessStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PrometheusJmxExporter) DeepCopyInto(out *PrometheusJmxExporter) {
 	*out = *in
@@ -53,6 +299,23 @@ func (in *PrometheusJmxExporter) DeepCopyObject() runtime.Object {
 	}
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusJmxExporterCondition) DeepCopyInto(out *PrometheusJmxExporterCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusJmxExporterCondition.
+func (in *PrometheusJmxExporterCondition) DeepCopy() *PrometheusJmxExporterCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusJmxExporterCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PrometheusJmxExporterConfig) DeepCopyInto(out *PrometheusJmxExporterConfig) {
 	*out = *in
@@ -288,7 +551,61 @@ func (in *PrometheusJmxExporterSpec) DeepCopyInto(out *PrometheusJmxExporterSpec
 			(*out)[key] = val
 		}
 	}
-	out.Config = in.Config
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.LabelSelector)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	in.Config.DeepCopyInto(&out.Config)
+	if in.PortRange != nil {
+		in, out := &in.PortRange, &out.PortRange
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(PortRange)
+			**out = **in
+		}
+	}
+	if in.ContainerSelector != nil {
+		in, out := &in.ContainerSelector, &out.ContainerSelector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ContainerSelector)
+			**out = **in
+		}
+	}
+	if in.ProcessSelector != nil {
+		in, out := &in.ProcessSelector, &out.ProcessSelector
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ProcessSelector)
+			**out = **in
+		}
+	}
+	if in.Monitor != nil {
+		in, out := &in.Monitor, &out.Monitor
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(MonitorSpec)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RetryPolicy)
+			**out = **in
+		}
+	}
 	return
 }
 
@@ -317,6 +634,20 @@ func (in *PrometheusJmxExporterStatus) DeepCopyInto(out *PrometheusJmxExporterSt
 			}
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]PrometheusJmxExporterCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Pods != nil {
+		in, out := &in.Pods, &out.Pods
+		*out = make([]PodStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -329,3 +660,40 @@ func (in *PrometheusJmxExporterStatus) DeepCopy() *PrometheusJmxExporterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelabelConfig) DeepCopyInto(out *RelabelConfig) {
+	*out = *in
+	if in.SourceLabels != nil {
+		in, out := &in.SourceLabels, &out.SourceLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelabelConfig.
+func (in *RelabelConfig) DeepCopy() *RelabelConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RelabelConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetryPolicy) DeepCopyInto(out *RetryPolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryPolicy.
+func (in *RetryPolicy) DeepCopy() *RetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,226 @@
+// Package attach implements the client side of the dynamic attach mechanism of HotSpot JVMs on Linux,
+// used to load java agents into running JVMs without the attach API of a JDK.
+//
+// The attach listener of the JVM is started by creating the .attach_pid<pid> trigger file in the working
+// directory or the temp directory of the JVM and sending it SIGQUIT. The JVM then listens on the
+// .java_pid<pid> UNIX socket of its temp directory which accepts commands from processes running
+// with the same effective user and group.
+package attach
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// protocolVersion is the version of the attach protocol
+	protocolVersion = "1"
+	// commandArgs is the number of arguments sent with each command
+	commandArgs = 3
+
+	pollInterval = 100 * time.Millisecond
+)
+
+// DefaultTimeout is the time to wait for the attach listener of the JVM to start and to respond
+const DefaultTimeout = 10 * time.Second
+
+// LoadAgent loads the java agent jar at agentPath into the JVM with pid passing options to the agentmain of
+// the agent. pid must be the pid of the JVM in its own pid namespace, which is the case when it's run in the
+// same container or in a container sharing the process namespace with the JVM.
+func LoadAgent(pid int, agentPath, options string, timeout time.Duration) error {
+	arg := agentPath
+	if len(options) > 0 {
+		arg += "=" + options
+	}
+
+	response, err := Execute(pid, timeout, "load", "instrument", "false", arg)
+	if err != nil {
+		return err
+	}
+
+	// the response of load is the return code of Agent_OnAttach: '0' on JDK 8, 'return code: 0' on newer JDKs
+	returnCode := strings.TrimPrefix(strings.TrimSpace(response), "return code: ")
+	if returnCode != "0" {
+		return fmt.Errorf("loading agent %s failed: %s", agentPath, strings.TrimSpace(response))
+	}
+
+	return nil
+}
+
+// Execute runs command with args through the attach listener of the JVM with pid, starting the listener if it's not
+// running yet. Returns the response of the JVM without the result code, an error if the result code is not zero.
+func Execute(pid int, timeout time.Duration, command string, args ...string) (string, error) {
+	if len(args) > commandArgs {
+		return "", fmt.Errorf("too many arguments for command %s", command)
+	}
+
+	uid, gid, err := processOwner(pid)
+	if err != nil {
+		return "", err
+	}
+	if euid := os.Geteuid(); euid != 0 && euid != uid {
+		return "", fmt.Errorf("process %d is run by user %d, attaching as user %d is not permitted", pid, uid, euid)
+	}
+
+	tmpDir := processTmpDir(pid)
+	socketPath := filepath.Join(tmpDir, ".java_pid"+strconv.Itoa(pid))
+
+	if !isSocket(socketPath) {
+		if err := startAttachListener(pid, uid, gid, tmpDir, socketPath, timeout); err != nil {
+			return "", err
+		}
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return "", fmt.Errorf("connecting to the attach listener of process %d failed: %v", pid, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	var request bytes.Buffer
+	for _, field := range append([]string{protocolVersion, command}, padArgs(args)...) {
+		request.WriteString(field)
+		request.WriteByte(0)
+	}
+	if _, err := conn.Write(request.Bytes()); err != nil {
+		return "", fmt.Errorf("sending command %s to process %d failed: %v", command, pid, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resultCode, err := reader.ReadString('\n')
+	if err != nil {
+		// the JVM closes the connection without response if the peer isn't run by its user
+		return "", fmt.Errorf("reading response of process %d failed: %v", pid, err)
+	}
+
+	response, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("reading response of process %d failed: %v", pid, err)
+	}
+
+	if code := strings.TrimSpace(resultCode); code != "0" {
+		return "", fmt.Errorf("command %s failed in process %d with code %s: %s", command, pid, code, strings.TrimSpace(string(response)))
+	}
+
+	return string(response), nil
+}
+
+// startAttachListener makes the JVM with pid start its attach listener and waits until it listens on socketPath
+func startAttachListener(pid, uid, gid int, tmpDir, socketPath string, timeout time.Duration) error {
+	attachFile, err := createAttachFile(pid, uid, gid, tmpDir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(attachFile)
+
+	if err := syscall.Kill(pid, syscall.SIGQUIT); err != nil {
+		return fmt.Errorf("signaling process %d failed: %v", pid, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !isSocket(socketPath) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("attach listener of process %d didn't start in %v, is it a HotSpot JVM with attach enabled?", pid, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	return nil
+}
+
+// createAttachFile creates the file triggering the start of the attach listener of the JVM with pid in its working directory,
+// or in tmpDir if the working directory is not writable. The file must be owned by the user of the JVM.
+func createAttachFile(pid, uid, gid int, tmpDir string) (string, error) {
+	name := ".attach_pid" + strconv.Itoa(pid)
+
+	var err error
+	for _, dir := range []string{filepath.Join("/proc", strconv.Itoa(pid), "cwd"), tmpDir} {
+		attachFile := filepath.Join(dir, name)
+
+		var file *os.File
+		file, err = os.OpenFile(attachFile, os.O_CREATE|os.O_WRONLY, 0660)
+		if err != nil {
+			continue
+		}
+		file.Close()
+
+		if os.Geteuid() == 0 {
+			if err = os.Chown(attachFile, uid, gid); err != nil {
+				os.Remove(attachFile)
+				continue
+			}
+		}
+
+		return attachFile, nil
+	}
+
+	return "", fmt.Errorf("creating attach file for process %d failed: %v", pid, err)
+}
+
+// processTmpDir returns the temp directory of the JVM with pid as seen from the current mount namespace
+func processTmpDir(pid int) string {
+	tmpDir := filepath.Join("/proc", strconv.Itoa(pid), "root", "tmp")
+	if _, err := os.Stat(tmpDir); err == nil {
+		return tmpDir
+	}
+
+	return os.TempDir()
+}
+
+// processOwner returns the effective user and group ids of the process with pid
+func processOwner(pid int) (int, int, error) {
+	content, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("process %d not found: %v", pid, err)
+	}
+
+	ids := make(map[string]int)
+	for _, line := range strings.Split(string(content), "\n") {
+		// Uid: and Gid: lines list the real, effective, saved and filesystem ids
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[0] != "Uid:" && fields[0] != "Gid:") {
+			continue
+		}
+
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid status of process %d: %v", pid, err)
+		}
+		ids[fields[0]] = id
+	}
+
+	uid, uidFound := ids["Uid:"]
+	gid, gidFound := ids["Gid:"]
+	if !uidFound || !gidFound {
+		return 0, 0, fmt.Errorf("owner of process %d not found", pid)
+	}
+
+	return uid, gid, nil
+}
+
+// isSocket returns true if path is a UNIX socket
+func isSocket(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// padArgs returns args padded with empty strings to the number of arguments the protocol requires
+func padArgs(args []string) []string {
+	padded := make([]string, commandArgs)
+	copy(padded, args)
+
+	return padded
+}
@@ -0,0 +1,198 @@
+package attach
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+// fakeJVM is the attach listener of a JVM answering the commands sent to the .java_pid<pid> socket of the test process
+type fakeJVM struct {
+	listener net.Listener
+	// requests are the fields of the commands received
+	requests chan []string
+}
+
+// startFakeJVM listens on the attach socket of the test process and answers each command with response.
+// The connections are closed without response if response is empty.
+func startFakeJVM(t *testing.T, response string) *fakeJVM {
+	pid := os.Getpid()
+	socketPath := filepath.Join(processTmpDir(pid), ".java_pid"+strconv.Itoa(pid))
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("listening on %s failed: %v", socketPath, err)
+	}
+
+	jvm := &fakeJVM{listener: listener, requests: make(chan []string, 1)}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			// the protocol version, the command and its arguments are terminated by NUL
+			reader := bufio.NewReader(conn)
+			var fields []string
+			for i := 0; i < 2+commandArgs; i++ {
+				field, err := reader.ReadString(0)
+				if err != nil {
+					break
+				}
+				fields = append(fields, strings.TrimSuffix(field, "\x00"))
+			}
+			jvm.requests <- fields
+
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+
+	return jvm
+}
+
+// stop stops listening and removes the socket
+func (jvm *fakeJVM) stop() {
+	jvm.listener.Close()
+}
+
+// request returns the fields of the command received
+func (jvm *fakeJVM) request(t *testing.T) []string {
+	select {
+	case request := <-jvm.requests:
+		return request
+	case <-time.After(testTimeout):
+		t.Fatal("no command received")
+		return nil
+	}
+}
+
+func TestLoadAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		options  string
+		expected string
+		err      string
+	}{
+		{
+			name:     "jdk 8",
+			response: "0\n0\n",
+			options:  "9020:/opt/jmx-exporter-loader/conf/config.yaml",
+			expected: "/opt/jmx-exporter-loader/agent.jar=9020:/opt/jmx-exporter-loader/conf/config.yaml",
+		},
+		{
+			name:     "newer jdk",
+			response: "0\nreturn code: 0\n",
+			expected: "/opt/jmx-exporter-loader/agent.jar",
+		},
+		{
+			name:     "agent failed",
+			response: "0\nreturn code: 102\n",
+			expected: "/opt/jmx-exporter-loader/agent.jar",
+			err:      "loading agent /opt/jmx-exporter-loader/agent.jar failed: return code: 102",
+		},
+		{
+			name:     "command failed",
+			response: "101\njava.lang.IllegalArgumentException: agent library not found\n",
+			expected: "/opt/jmx-exporter-loader/agent.jar",
+			err:      "command load failed in process " + strconv.Itoa(os.Getpid()) + " with code 101: java.lang.IllegalArgumentException",
+		},
+		{
+			name:     "connection closed",
+			expected: "/opt/jmx-exporter-loader/agent.jar",
+			err:      "reading response of process",
+		},
+	}
+
+	for _, test := range tests {
+		jvm := startFakeJVM(t, test.response)
+
+		err := LoadAgent(os.Getpid(), "/opt/jmx-exporter-loader/agent.jar", test.options, testTimeout)
+
+		if request := jvm.request(t); !reflect.DeepEqual(request, []string{protocolVersion, "load", "instrument", "false", test.expected}) {
+			t.Errorf("%s: unexpected request %q", test.name, request)
+		}
+
+		if len(test.err) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.err, err)
+		}
+
+		jvm.stop()
+	}
+}
+
+func TestExecute(t *testing.T) {
+	jvm := startFakeJVM(t, "0\nfoo=bar\n")
+	defer jvm.stop()
+
+	response, err := Execute(os.Getpid(), testTimeout, "properties")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response != "foo=bar\n" {
+		t.Errorf("expected response without the result code, got %q", response)
+	}
+
+	// the arguments not given are sent empty
+	if request := jvm.request(t); !reflect.DeepEqual(request, []string{protocolVersion, "properties", "", "", ""}) {
+		t.Errorf("unexpected request %q", request)
+	}
+
+	if _, err := Execute(os.Getpid(), testTimeout, "load", "instrument", "false", "agent.jar", "extra"); err == nil {
+		t.Error("expected error for too many arguments")
+	}
+}
+
+func TestProcessOwner(t *testing.T) {
+	uid, gid, err := processOwner(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uid != os.Geteuid() || gid != os.Getegid() {
+		t.Errorf("expected owner %d:%d, got %d:%d", os.Geteuid(), os.Getegid(), uid, gid)
+	}
+
+	if _, _, err := processOwner(-1); err == nil {
+		t.Error("expected error for missing process")
+	}
+}
+
+func TestCreateAttachFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "attach")
+	if err != nil {
+		t.Fatalf("creating temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	attachFile, err := createAttachFile(os.Getpid(), os.Geteuid(), os.Getegid(), tmpDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(attachFile)
+
+	// the working directory of the JVM is preferred over its temp directory
+	name := ".attach_pid" + strconv.Itoa(os.Getpid())
+	if expected := filepath.Join("/proc", strconv.Itoa(os.Getpid()), "cwd", name); attachFile != expected {
+		t.Errorf("expected attach file %s, got %s", expected, attachFile)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Errorf("attach file not created in the working directory: %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+)
+
+const (
+	// labelIndex indexes pods by each of their labels
+	labelIndex = "byLabel"
+	// selectorIndex indexes PrometheusJmxExporters by a label all the pods they select must have
+	selectorIndex = "bySelector"
+)
+
+// namespaceInformers are the informers of the namespaced resources of a watched namespace
+type namespaceInformers struct {
+	pods                   cache.SharedIndexInformer
+	prometheusJmxExporters cache.SharedIndexInformer
+}
+
+var (
+	// informers are the informers of the watched namespaces by namespace, a single informer
+	// is registered under metav1.NamespaceAll if all namespaces are watched
+	informers map[string]*namespaceInformers
+	// clusterPrometheusJmxExporterInformer and namespaceInformer are only run if cluster scoped
+	// exporters are enabled
+	clusterPrometheusJmxExporterInformer cache.SharedIndexInformer
+	namespaceInformer                    cache.SharedIndexInformer
+)
+
+// RunInformers starts the informers of the resources the operator reads and waits until their
+// caches are synced. Reconciliation reads pods and exporters from these caches instead of
+// listing them on each event. WatchNamespaces must be called before.
+func RunInformers(stopCh <-chan struct{}) error {
+	restClient, err := newPrometheusJmxExporterRESTClient(kubeConfig)
+	if err != nil {
+		return fmt.Errorf("creating rest client for prometheusjmxexporters failed: %v", err)
+	}
+
+	namespaces := watchedNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var synced []cache.InformerSynced
+	run := func(informer cache.SharedIndexInformer) {
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+
+	informers = make(map[string]*namespaceInformers, len(namespaces))
+	for _, namespace := range namespaces {
+		nsInformers := &namespaceInformers{
+			pods: coreinformers.NewPodInformer(kubeClient, namespace, 0, cache.Indexers{
+				cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+				labelIndex:           podLabelIndexFunc,
+			}),
+			prometheusJmxExporters: cache.NewSharedIndexInformer(
+				cache.NewListWatchFromClient(restClient, "prometheusjmxexporters", namespace, fields.Everything()),
+				&v1alpha1.PrometheusJmxExporter{},
+				0,
+				cache.Indexers{
+					cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+					selectorIndex:        prometheusJmxExporterSelectorIndexFunc,
+				}),
+		}
+
+		run(nsInformers.pods)
+		run(nsInformers.prometheusJmxExporters)
+		informers[namespace] = nsInformers
+	}
+
+	if clusterExportersEnabled {
+		clusterPrometheusJmxExporterInformer = cache.NewSharedIndexInformer(
+			cache.NewListWatchFromClient(restClient, "clusterprometheusjmxexporters", metav1.NamespaceAll, fields.Everything()),
+			&v1alpha1.ClusterPrometheusJmxExporter{},
+			0,
+			cache.Indexers{})
+		namespaceInformer = coreinformers.NewNamespaceInformer(kubeClient, 0, cache.Indexers{})
+
+		run(clusterPrometheusJmxExporterInformer)
+		run(namespaceInformer)
+	}
+
+	logrus.Info("Waiting for informer caches to sync")
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return fmt.Errorf("failed to sync informer caches")
+	}
+
+	return nil
+}
+
+// newPrometheusJmxExporterRESTClient returns a rest client for the resources of the banzaicloud.com/v1alpha1 API group
+func newPrometheusJmxExporterRESTClient(config *rest.Config) (*rest.RESTClient, error) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	restConfig := *config
+	restConfig.GroupVersion = &v1alpha1.SchemeGroupVersion
+	restConfig.APIPath = "/apis"
+	restConfig.ContentType = runtime.ContentTypeJSON
+	restConfig.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+
+	return rest.RESTClientFor(&restConfig)
+}
+
+// informersFor returns the informers of namespace, nil if namespace is not watched
+func informersFor(namespace string) *namespaceInformers {
+	if nsInformers, ok := informers[metav1.NamespaceAll]; ok {
+		return nsInformers
+	}
+
+	return informers[namespace]
+}
+
+// labelIndexKey returns the index key of the label key=value in namespace
+func labelIndexKey(namespace, key, value string) string {
+	return namespace + "/" + key + "=" + value
+}
+
+// selectorIndexKey returns the key under which a PrometheusJmxExporter with selector is indexed: one of
+// the matchLabels of selector as all the pods selected have it. Selectors without matchLabels are indexed
+// under an empty label as these may select pods with any labels.
+func selectorIndexKey(namespace string, selector *metav1.LabelSelector) string {
+	if len(selector.MatchLabels) == 0 {
+		return labelIndexKey(namespace, "", "")
+	}
+
+	keys := make([]string, 0, len(selector.MatchLabels))
+	for key := range selector.MatchLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return labelIndexKey(namespace, keys[0], selector.MatchLabels[keys[0]])
+}
+
+// podLabelIndexFunc indexes pods by each of their labels
+func podLabelIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	keys := make([]string, 0, len(pod.Labels))
+	for key, value := range pod.Labels {
+		keys = append(keys, labelIndexKey(pod.Namespace, key, value))
+	}
+
+	return keys, nil
+}
+
+// prometheusJmxExporterSelectorIndexFunc indexes PrometheusJmxExporters by the key returned by selectorIndexKey
+func prometheusJmxExporterSelectorIndexFunc(obj interface{}) ([]string, error) {
+	prometheusJmxExporter, ok := obj.(*v1alpha1.PrometheusJmxExporter)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T", obj)
+	}
+
+	return []string{selectorIndexKey(prometheusJmxExporter.Namespace, prometheusJmxExporter.Spec.PodSelector())}, nil
+}
+
+// cachedPrometheusJmxExporters returns copies of the PrometheusJmxExporters stored under indexKey of index
+func cachedPrometheusJmxExporters(namespace, index, indexKey string) ([]v1alpha1.PrometheusJmxExporter, error) {
+	nsInformers := informersFor(namespace)
+	if nsInformers == nil {
+		return nil, nil
+	}
+
+	objects, err := nsInformers.prometheusJmxExporters.GetIndexer().ByIndex(index, indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prometheusJmxExporters := make([]v1alpha1.PrometheusJmxExporter, 0, len(objects))
+	for _, obj := range objects {
+		prometheusJmxExporters = append(prometheusJmxExporters, *obj.(*v1alpha1.PrometheusJmxExporter).DeepCopy())
+	}
+
+	return prometheusJmxExporters, nil
+}
+
+// cachedPods returns copies of the pods stored under indexKey of index
+func cachedPods(namespace, index, indexKey string) ([]v1.Pod, error) {
+	nsInformers := informersFor(namespace)
+	if nsInformers == nil {
+		return nil, nil
+	}
+
+	objects, err := nsInformers.pods.GetIndexer().ByIndex(index, indexKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]v1.Pod, 0, len(objects))
+	for _, obj := range objects {
+		pods = append(pods, *obj.(*v1.Pod).DeepCopy())
+	}
+
+	return pods, nil
+}
+
+// queryPodPrometheusJmxExporters returns the PrometheusJmxExporters which may select pod: the ones indexed
+// under any of its labels or under the empty label, and the ones derived from ClusterPrometheusJmxExporters
+func queryPodPrometheusJmxExporters(pod *v1.Pod) (*v1alpha1.PrometheusJmxExporterList, error) {
+	jmxExporterList := v1alpha1.PrometheusJmxExporterList{}
+
+	indexKeys := []string{labelIndexKey(pod.Namespace, "", "")}
+	for key, value := range pod.Labels {
+		indexKeys = append(indexKeys, labelIndexKey(pod.Namespace, key, value))
+	}
+
+	for _, indexKey := range indexKeys {
+		prometheusJmxExporters, err := cachedPrometheusJmxExporters(pod.Namespace, selectorIndex, indexKey)
+		if err != nil {
+			return nil, err
+		}
+		jmxExporterList.Items = append(jmxExporterList.Items, prometheusJmxExporters...)
+	}
+
+	derivedJmxExporters, err := queryDerivedPrometheusJmxExporters(pod.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	jmxExporterList.Items = append(jmxExporterList.Items, derivedJmxExporters...)
+
+	return &jmxExporterList, nil
+}
@@ -0,0 +1,139 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// finalizedObject is a resource the operator places its finalizer on
+type finalizedObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// hasFinalizer returns true if object has the finalizer of the operator
+func hasFinalizer(object finalizedObject) bool {
+	for _, finalizer := range object.GetFinalizers() {
+		if finalizer == prometheusJmxExporterFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addFinalizer registers the finalizer of the operator on object so that
+// the operator gets the chance to clean up the pods before object is removed
+func addFinalizer(object finalizedObject) error {
+	logrus.Infof("%s: '%s/%s' : Add finalizer",
+		object.GetObjectKind().GroupVersionKind().Kind,
+		object.GetNamespace(),
+		object.GetName())
+
+	object.SetFinalizers(append(object.GetFinalizers(), prometheusJmxExporterFinalizer))
+
+	return updateObject(object)
+}
+
+// removeFinalizer removes the finalizer of the operator from object which
+// lets Kubernetes to complete the deletion of object
+func removeFinalizer(object finalizedObject) error {
+	logrus.Infof("%s: '%s/%s' : Remove finalizer",
+		object.GetObjectKind().GroupVersionKind().Kind,
+		object.GetNamespace(),
+		object.GetName())
+
+	var finalizers []string
+	for _, finalizer := range object.GetFinalizers() {
+		if finalizer != prometheusJmxExporterFinalizer {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	object.SetFinalizers(finalizers)
+
+	return updateObject(object)
+}
+
+// cleanupPrometheusJmxExporter un-instruments all pods that were processed on behalf of
+// prometheusJmxExporter then removes the finalizer of the operator. The finalizer is removed
+// even if some of the pods couldn't be cleaned up, otherwise the deletion would never complete.
+func (h *Handler) cleanupPrometheusJmxExporter(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) error {
+	h.cleanupPods(prometheusJmxExporter)
+
+	prometheusJmxExporter.Status = v1alpha1.PrometheusJmxExporterStatus{}
+
+	return removeFinalizer(prometheusJmxExporter)
+}
+
+// cleanupPods un-instruments all pods that were processed on behalf of prometheusJmxExporter.
+// Failures are logged, pods which are crash looping or gone can't be cleaned up.
+func (h *Handler) cleanupPods(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+	logrus.Infof("PrometheusJmxExporter: '%s/%s' : Cleaning up pods",
+		prometheusJmxExporter.Namespace,
+		prometheusJmxExporter.Name)
+
+	podList, err := queryPrometheusJmxExporterPods(prometheusJmxExporter)
+	if err != nil {
+		logrus.Errorf("Error during querying pods : %v", err)
+		return
+	}
+
+	for i := 0; i < len(podList.Items); i++ {
+		pod := &podList.Items[i]
+
+		if _, ok := pod.Annotations[prometheusJmxExporterAnnotationKey]; !ok {
+			continue
+		}
+
+		if err := h.detachPod(pod); err != nil {
+			logrus.Errorf("Cleaning up pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+			continue
+		}
+
+		recordEvent(nil, pod, v1.EventTypeNormal, eventReasonJmxExporterDetached,
+			"Prometheus jmx exporter detached as PrometheusJmxExporter '%s' is deleted, "+
+				"the agent keeps serving metrics until the java process is restarted", prometheusJmxExporter.Name)
+	}
+}
+
+// detachPod reverts the changes made by processPod: removes the files copied into the container
+// and the annotations placed on the pod. The container ports of a pod are immutable thus these are left as is.
+//
+// Note: the jmx exporter agent can not be unloaded from a running JVM and it has no means to stop its
+// http server, thus it remains bound to the port until the java process is restarted. Prometheus
+// stops scraping it as the pod no longer advertises the endpoint.
+func (h *Handler) detachPod(pod *v1.Pod) error {
+	logrus.Infof("Detaching prometheus jmx exporter from pod '%s/%s'", pod.Namespace, pod.Name)
+
+	if pod.Annotations[prometheusJmxExporterAnnotationKey] == prometheusJmxExporterAnnotationVerified {
+		containers := instrumentedContainers(pod)
+		for i := 0; i < len(containers); i++ {
+			container := containers[i]
+
+			if err := removePrometheusJmxExporterFiles(h.executor, pod, &container); err != nil {
+				// the files are not in use by the agent anymore, thus failing to remove them
+				// must not block the deletion of the prometheusJmxExporter
+				logrus.Warnf("Removing prometheus jmx exporter files from '%s/%s/%s' failed: %v",
+					pod.Namespace, pod.Name, container.Name, err)
+			}
+		}
+	}
+
+	for _, key := range podAnnotationKeys {
+		delete(pod.Annotations, key)
+	}
+
+	return updateObject(pod)
+}
+
+// removePrometheusJmxExporterFiles removes the jars and config copied to the container
+func removePrometheusJmxExporterFiles(executor PodExecutor, pod *v1.Pod, container *v1.Container) error {
+	logrus.Infof("Removing '%s/%s/%s:%s'", pod.Namespace, pod.Name, container.Name, prometheusJmxExporterTargetDir)
+
+	_, err := execCommand(executor, pod.Namespace, pod.Name, nil, container, "rm", "-rf", prometheusJmxExporterTargetDir)
+
+	return err
+}
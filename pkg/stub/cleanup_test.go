@@ -0,0 +1,101 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"path"
+	"testing"
+)
+
+func TestCleanupPrometheusJmxExporter(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	annotations := map[string]string{"owner": "team-a"}
+	for _, key := range podAnnotationKeys {
+		annotations[key] = "stale"
+	}
+	annotations[prometheusJmxExporterAnnotationKey] = prometheusJmxExporterAnnotationVerified
+	annotations[prometheusScrapeAnnotationKey] = "true"
+	annotations[prometheusJmxExporterEndpointsAnnotationKey] = "app/42:9020"
+
+	instrumented := newTestPod(api, "app-1", "app")
+	instrumented.Annotations = annotations
+	api.store("pods", instrumented)
+
+	// pods never processed are left untouched
+	untouched := newTestPod(api, "app-2", "app")
+
+	key := fakeContainerKey("default", "app-1", "app")
+	executor.storeFile(key, path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAgentJar), []byte("agent"))
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Finalizers = []string{prometheusJmxExporterFinalizer}
+	api.store("prometheusjmxexporters", prometheusJmxExporter)
+	installFakeCache(t, instrumented, untouched, prometheusJmxExporter)
+
+	if err := handler.cleanupPrometheusJmxExporter(prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	for _, key := range podAnnotationKeys {
+		if value, ok := stored.Annotations[key]; ok {
+			t.Errorf("expected annotation %s removed, got '%s'", key, value)
+		}
+	}
+	if owner := stored.Annotations["owner"]; owner != "team-a" {
+		t.Errorf("expected the annotations of the user kept, got %v", stored.Annotations)
+	}
+	if len(executor.Files[key]) > 0 {
+		t.Errorf("expected the copied files removed, got %v", executor.Files[key])
+	}
+	if updates := api.updateCount("pods", "default", "app-2"); updates != 0 {
+		t.Errorf("expected the pod never processed not updated, got %d updates", updates)
+	}
+
+	var storedExporter v1alpha1.PrometheusJmxExporter
+	api.get(t, "prometheusjmxexporters", "default", "test", &storedExporter)
+
+	if hasFinalizer(&storedExporter) {
+		t.Error("expected the finalizer removed")
+	}
+}
+
+func TestCleanupPrometheusJmxExporterIgnoresFailingPods(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	executor.Errors["rm"] = fmt.Errorf("container not found")
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	pod.Annotations = map[string]string{prometheusJmxExporterAnnotationKey: prometheusJmxExporterAnnotationVerified}
+	api.store("pods", pod)
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Finalizers = []string{prometheusJmxExporterFinalizer}
+	api.store("prometheusjmxexporters", prometheusJmxExporter)
+	installFakeCache(t, pod, prometheusJmxExporter)
+
+	if err := handler.cleanupPrometheusJmxExporter(prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var storedExporter v1alpha1.PrometheusJmxExporter
+	api.get(t, "prometheusjmxexporters", "default", "test", &storedExporter)
+
+	if hasFinalizer(&storedExporter) {
+		t.Error("expected the finalizer removed even if the files couldn't be removed")
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if _, ok := stored.Annotations[prometheusJmxExporterAnnotationKey]; ok {
+		t.Error("expected the annotations removed even if the files couldn't be removed")
+	}
+}
@@ -0,0 +1,88 @@
+package stub
+
+import (
+	"fmt"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var (
+	kubeClient kubernetes.Interface
+	kubeConfig *rest.Config
+)
+
+// NewKubeConfig returns the config to reach the Kubernetes API server with. The kubeconfig file is taken from
+// kubeconfig or the KUBECONFIG or KUBERNETES_CONFIG environment variables in this order, kubeContext selects
+// its context, the current context is used if it's empty. Falls back to the in-cluster config if no kubeconfig
+// file is given.
+func NewKubeConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	loadingRules := &clientcmd.ClientConfigLoadingRules{}
+
+	switch {
+	case len(kubeconfig) > 0:
+		loadingRules.ExplicitPath = kubeconfig
+	case len(os.Getenv(clientcmd.RecommendedConfigPathEnvVar)) > 0:
+		loadingRules.Precedence = filepath.SplitList(os.Getenv(clientcmd.RecommendedConfigPathEnvVar))
+	case len(os.Getenv("KUBERNETES_CONFIG")) > 0:
+		loadingRules.ExplicitPath = os.Getenv("KUBERNETES_CONFIG")
+	default:
+		return newInClusterConfig()
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig failed: %v", err)
+	}
+
+	return config, nil
+}
+
+// newInClusterConfig returns the config of the service account of the operator pod
+func newInClusterConfig() (*rest.Config, error) {
+	// Work around https://github.com/kubernetes/kubernetes/issues/40973
+	// See https://github.com/coreos/etcd-operator/issues/731#issuecomment-283804819
+	if len(os.Getenv("KUBERNETES_SERVICE_HOST")) == 0 {
+		addrs, err := net.LookupHost("kubernetes.default.svc")
+		if err != nil {
+			return nil, fmt.Errorf("not running in a cluster and no kubeconfig given: %v", err)
+		}
+		os.Setenv("KUBERNETES_SERVICE_HOST", addrs[0])
+	}
+	if len(os.Getenv("KUBERNETES_SERVICE_PORT")) == 0 {
+		os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading in-cluster config failed: %v", err)
+	}
+
+	return config, nil
+}
+
+// InitClients creates the clients the operator talks to the Kubernetes API server through config with, all the
+// resources are read, written and watched through these. It must be called before the informers and the handler are started.
+func InitClients(config *rest.Config) error {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("creating kubernetes client failed: %v", err)
+	}
+
+	recorder, err := newEventRecorder(client)
+	if err != nil {
+		return err
+	}
+
+	kubeClient = client
+	kubeConfig = config
+	eventRecorder = recorder
+	initResourceClients(kubeClient, kubeConfig)
+
+	return nil
+}
@@ -0,0 +1,360 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+)
+
+var (
+	// watchedNamespaces are the namespaces managed by the operator, all namespaces are managed if it's empty
+	watchedNamespaces []string
+	// clusterExportersEnabled is true if the operator handles ClusterPrometheusJmxExporters
+	clusterExportersEnabled bool
+)
+
+// WatchNamespaces restricts the namespaces managed by the operator to namespaces, all namespaces are managed
+// if it's empty. clusterExporters enables handling ClusterPrometheusJmxExporters which requires cluster wide
+// permissions.
+func WatchNamespaces(namespaces []string, clusterExporters bool) {
+	watchedNamespaces = namespaces
+	clusterExportersEnabled = clusterExporters
+}
+
+// isWatchedNamespace returns true if namespace is managed by the operator
+func isWatchedNamespace(namespace string) bool {
+	if len(watchedNamespaces) == 0 {
+		return true
+	}
+
+	return containsString(watchedNamespaces, namespace)
+}
+
+// isInNamespace returns true if namespace is the namespace of pod. Statuses recorded by earlier
+// versions of the operator have no namespace, these belong to the namespace of the pod.
+func isInNamespace(namespace string, pod *v1.Pod) bool {
+	return len(namespace) == 0 || namespace == pod.Namespace
+}
+
+// isSameExporter returns true if prometheusJmxExporter and other are the same PrometheusJmxExporter
+// or derived from the same ClusterPrometheusJmxExporter
+func isSameExporter(prometheusJmxExporter, other *v1alpha1.PrometheusJmxExporter) bool {
+	return prometheusJmxExporter.Name == other.Name &&
+		(prometheusJmxExporter.ClusterOwner() == nil) == (other.ClusterOwner() == nil)
+}
+
+// queryClusterPrometheusJmxExporters returns all ClusterPrometheusJmxExporters
+func queryClusterPrometheusJmxExporters() (*v1alpha1.ClusterPrometheusJmxExporterList, error) {
+	clusterJmxExporterList := v1alpha1.ClusterPrometheusJmxExporterList{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       v1alpha1.ClusterPrometheusJmxExporterKind,
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		},
+	}
+
+	if clusterPrometheusJmxExporterInformer == nil {
+		return &clusterJmxExporterList, nil
+	}
+
+	for _, obj := range clusterPrometheusJmxExporterInformer.GetStore().List() {
+		clusterJmxExporterList.Items = append(clusterJmxExporterList.Items, *obj.(*v1alpha1.ClusterPrometheusJmxExporter).DeepCopy())
+	}
+
+	return &clusterJmxExporterList, nil
+}
+
+// queryDerivedPrometheusJmxExporters returns the PrometheusJmxExporters derived from the ClusterPrometheusJmxExporters
+// which select namespace
+func queryDerivedPrometheusJmxExporters(namespace string) ([]v1alpha1.PrometheusJmxExporter, error) {
+	if !clusterExportersEnabled || !isWatchedNamespace(namespace) {
+		return nil, nil
+	}
+
+	obj, exists, err := namespaceInformer.GetStore().GetByKey(namespace)
+	if err != nil {
+		logrus.Errorf("Failed to get namespace '%s': %v", namespace, err)
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("namespace '%s' not found", namespace)
+	}
+	ns := obj.(*v1.Namespace)
+
+	clusterJmxExporterList, err := queryClusterPrometheusJmxExporters()
+	if err != nil {
+		return nil, err
+	}
+
+	var prometheusJmxExporters []v1alpha1.PrometheusJmxExporter
+	for i := 0; i < len(clusterJmxExporterList.Items); i++ {
+		clusterPrometheusJmxExporter := &clusterJmxExporterList.Items[i]
+
+		selector, err := namespaceSelector(clusterPrometheusJmxExporter)
+		if err != nil {
+			logrus.Warnf("Invalid namespaceSelector of clusterprometheusjmxexporter '%s': %v", clusterPrometheusJmxExporter.Name, err)
+			continue
+		}
+
+		if selector.Matches(labels.Set(ns.Labels)) {
+			prometheusJmxExporters = append(prometheusJmxExporters, *clusterPrometheusJmxExporter.ForNamespace(namespace))
+		}
+	}
+
+	return prometheusJmxExporters, nil
+}
+
+// namespaceSelector returns the selector of the namespaces of clusterPrometheusJmxExporter
+func namespaceSelector(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter) (labels.Selector, error) {
+	if clusterPrometheusJmxExporter.Spec.NamespaceSelector == nil {
+		return labels.Everything(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(clusterPrometheusJmxExporter.Spec.NamespaceSelector)
+}
+
+// queryClusterPrometheusJmxExporterNamespaces returns the watched namespaces selected by clusterPrometheusJmxExporter
+func queryClusterPrometheusJmxExporterNamespaces(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter) ([]string, error) {
+	selector, err := namespaceSelector(clusterPrometheusJmxExporter)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespaceInformer == nil {
+		return nil, nil
+	}
+
+	var namespaces []string
+	for _, obj := range namespaceInformer.GetStore().List() {
+		namespace := obj.(*v1.Namespace)
+
+		if isWatchedNamespace(namespace.Name) && selector.Matches(labels.Set(namespace.Labels)) {
+			namespaces = append(namespaces, namespace.Name)
+		}
+	}
+
+	return namespaces, nil
+}
+
+// reconcileClusterPrometheusJmxExporter instruments the pods selected by clusterPrometheusJmxExporter
+// in each selected namespace and records their state in its status
+func (h *Handler) reconcileClusterPrometheusJmxExporter(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter) error {
+	if clusterPrometheusJmxExporter.DeletionTimestamp != nil {
+		logrus.Infof("ClusterPrometheusJmxExporter '%s' is being deleted", clusterPrometheusJmxExporter.Name)
+
+		if hasFinalizer(clusterPrometheusJmxExporter) {
+			return h.cleanupClusterPrometheusJmxExporter(clusterPrometheusJmxExporter)
+		}
+
+		return nil
+	}
+
+	if !hasFinalizer(clusterPrometheusJmxExporter) {
+		if err := addFinalizer(clusterPrometheusJmxExporter); err != nil {
+			logrus.Errorf("Adding finalizer to '%s' failed: %v", clusterPrometheusJmxExporter.Name, err)
+			return err
+		}
+	}
+
+	status := newPrometheusJmxExporterStatus(clusterPrometheusJmxExporter.Status, clusterPrometheusJmxExporter.Generation)
+	startupMode := clusterPrometheusJmxExporter.Spec.IsStartupMode()
+
+	namespaces, err := queryClusterPrometheusJmxExporterNamespaces(clusterPrometheusJmxExporter)
+	if err != nil {
+		return err
+	}
+
+	// the config is the same in all namespaces
+	config, err := loadConfig(clusterPrometheusJmxExporter.ForNamespace(metav1.NamespaceNone))
+
+	setConfigValidCondition(&status, err)
+	if err != nil {
+		logrus.Errorf("Error during retrieving prometheus jmx exporter config")
+
+		recordClusterEvent(clusterPrometheusJmxExporter, v1.EventTypeWarning, eventReasonConfigLoadFailed,
+			"Loading config from %s failed: %v", describeConfigSource(&clusterPrometheusJmxExporter.Spec.Config), err)
+
+		keepPodStatuses(&status, clusterPrometheusJmxExporter.Status)
+		updateReadyConditions(&status)
+		updateClusterPrometheusJmxExporterStatus(clusterPrometheusJmxExporter, status)
+		return err
+	}
+
+	var pods []v1.Pod
+	var conflictErr error
+	var overlapping []string
+
+	for _, namespace := range namespaces {
+		prometheusJmxExporter := clusterPrometheusJmxExporter.ForNamespace(namespace)
+
+		if err := syncMonitor(prometheusJmxExporter); err != nil {
+			logrus.Errorf("Syncing monitor of '%s/%s' failed: %v", namespace, prometheusJmxExporter.Name, err)
+
+			recordEvent(prometheusJmxExporter, nil, v1.EventTypeWarning, eventReasonMonitorSyncFailed, "%v", err)
+		}
+
+		podList, err := queryPrometheusJmxExporterPods(prometheusJmxExporter)
+		if err != nil {
+			logrus.Errorf("Error during querying pods : %v", err)
+			return err
+		}
+
+		logrus.Infof("Pods found: namespace='%s', %s", namespace, formatSimplePods(podList.Items))
+
+		for _, name := range overlappingExporterNames(prometheusJmxExporter) {
+			overlapping = append(overlapping, namespace+"/"+name)
+		}
+
+		if len(podList.Items) == 0 {
+			continue
+		}
+
+		if err := checkPrometheusJmxExporterConflict(podList, prometheusJmxExporter); err != nil {
+			recordEvent(prometheusJmxExporter, nil, v1.EventTypeWarning, eventReasonSelectorConflict, "%v", err)
+
+			conflictErr = err
+			continue
+		}
+
+		if startupMode {
+			// pods are instrumented by the webhook at creation
+			h.syncPodConfigs(podList.Items, config)
+		} else {
+			processPods(podList.Items)
+		}
+
+		pods = append(pods, podList.Items...)
+	}
+
+	setConflictCondition(&status, conflictErr, overlapping)
+	setPodStatuses(&status, pods, startupMode)
+	updateReadyConditions(&status)
+
+	return updateClusterPrometheusJmxExporterStatus(clusterPrometheusJmxExporter, status)
+}
+
+// cleanupClusterPrometheusJmxExporter un-instruments the pods processed on behalf of clusterPrometheusJmxExporter
+// in all namespaces then removes the finalizer of the operator even if some of the pods couldn't be cleaned up
+func (h *Handler) cleanupClusterPrometheusJmxExporter(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter) error {
+	namespaces, err := queryClusterPrometheusJmxExporterNamespaces(clusterPrometheusJmxExporter)
+	if err != nil {
+		logrus.Errorf("Error during querying namespaces : %v", err)
+	}
+
+	for _, namespace := range namespaces {
+		h.cleanupPods(clusterPrometheusJmxExporter.ForNamespace(namespace))
+	}
+
+	clusterPrometheusJmxExporter.Status = v1alpha1.PrometheusJmxExporterStatus{}
+
+	return removeFinalizer(clusterPrometheusJmxExporter)
+}
+
+// updateClusterPrometheusJmxExporterStatus stores status on clusterPrometheusJmxExporter if it differs from the current one
+func updateClusterPrometheusJmxExporterStatus(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter, status v1alpha1.PrometheusJmxExporterStatus) error {
+	if clusterPrometheusJmxExporter.Status.Equals(status) {
+		return nil
+	}
+
+	clusterPrometheusJmxExporter.Status = status
+
+	logrus.Infof("ClusterPrometheusJmxExporter: '%s' : Update status", clusterPrometheusJmxExporter.Name)
+
+	return updateObject(clusterPrometheusJmxExporter)
+}
+
+// storePrometheusJmxExporterStatus applies update to the latest status of prometheusJmxExporter and stores it, retried
+// on conflict as the status is updated by the events of the different pods concurrently. The status of the
+// PrometheusJmxExporters derived from a ClusterPrometheusJmxExporter is stored on the ClusterPrometheusJmxExporter:
+// update is applied to the pods of the namespace of prometheusJmxExporter which are merged into its latest status.
+// update returns false if it didn't change the status.
+func storePrometheusJmxExporterStatus(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, update func(*v1alpha1.PrometheusJmxExporter) bool) error {
+	clusterOwner := prometheusJmxExporter.ClusterOwner()
+	if clusterOwner == nil {
+		return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := v1alpha1.PrometheusJmxExporter{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       v1alpha1.PrometheusJmxExporterKind,
+					APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      prometheusJmxExporter.Name,
+					Namespace: prometheusJmxExporter.Namespace,
+				},
+			}
+
+			if err := getObject(&latest); err != nil {
+				return fmt.Errorf("failed to get prometheusjmxexporter '%s/%s': %v", latest.Namespace, latest.Name, err)
+			}
+
+			if !update(&latest) {
+				return nil
+			}
+			updateReadyConditions(&latest.Status)
+
+			logrus.Infof("PrometheusJmxExporter: '%s/%s' : Update status", latest.Namespace, latest.Name)
+
+			return updateObject(&latest)
+		})
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clusterPrometheusJmxExporter := v1alpha1.ClusterPrometheusJmxExporter{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       v1alpha1.ClusterPrometheusJmxExporterKind,
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: clusterOwner.Name,
+			},
+		}
+
+		if err := getObject(&clusterPrometheusJmxExporter); err != nil {
+			return fmt.Errorf("failed to get clusterprometheusjmxexporter '%s': %v", clusterOwner.Name, err)
+		}
+
+		latest := clusterPrometheusJmxExporter.ForNamespace(prometheusJmxExporter.Namespace)
+		if !update(latest) {
+			return nil
+		}
+
+		status := mergeNamespaceStatus(clusterPrometheusJmxExporter.Status, latest.Status, prometheusJmxExporter.Namespace)
+		updateReadyConditions(&status)
+
+		return updateClusterPrometheusJmxExporterStatus(&clusterPrometheusJmxExporter, status)
+	})
+}
+
+// mergeNamespaceStatus returns status with the endpoints and the pods of namespace replaced by the ones of namespaceStatus
+func mergeNamespaceStatus(status, namespaceStatus v1alpha1.PrometheusJmxExporterStatus, namespace string) v1alpha1.PrometheusJmxExporterStatus {
+	merged := *status.DeepCopy()
+	merged.MetricsEndpoints = nil
+	merged.Pods = nil
+
+	for _, endpoint := range status.MetricsEndpoints {
+		if endpoint.Namespace != namespace {
+			merged.MetricsEndpoints = append(merged.MetricsEndpoints, endpoint.DeepCopy())
+		}
+	}
+	for _, endpoint := range namespaceStatus.MetricsEndpoints {
+		if endpoint.Namespace == namespace {
+			merged.MetricsEndpoints = append(merged.MetricsEndpoints, endpoint.DeepCopy())
+		}
+	}
+
+	for _, podStatus := range status.Pods {
+		if podStatus.Namespace != namespace {
+			merged.Pods = append(merged.Pods, *podStatus.DeepCopy())
+		}
+	}
+	for _, podStatus := range namespaceStatus.Pods {
+		if podStatus.Namespace == namespace {
+			merged.Pods = append(merged.Pods, *podStatus.DeepCopy())
+		}
+	}
+
+	return merged
+}
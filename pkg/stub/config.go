@@ -0,0 +1,231 @@
+package stub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// loadConfig returns the prometheus jmx exporter config of prometheusJmxExporter either given inline
+// or retrieved from the referenced configMap
+func loadConfig(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) (*v1alpha1.PrometheusJmxExporterConfig, error) {
+	source := &prometheusJmxExporter.Spec.Config
+	if err := source.Validate(); err != nil {
+		return nil, err
+	}
+
+	if source.Inline != nil {
+		logrus.Infof("Using inline prometheus jmx exporter config of '%s/%s'",
+			prometheusJmxExporter.Namespace,
+			prometheusJmxExporter.Name)
+
+		return source.Inline.DeepCopy(), nil
+	}
+
+	namespace := configMapNamespace(prometheusJmxExporter)
+	if len(namespace) == 0 {
+		return nil, fmt.Errorf("config.configMapNamespace must be set for clusterprometheusjmxexporter '%s'", prometheusJmxExporter.Name)
+	}
+
+	logrus.Infof(
+		"Retrieving prometheus jmx exporter config from configMap '%s/%s:%s'",
+		namespace,
+		source.ConfigMapName,
+		source.ConfigMapKey)
+
+	return getConfig(namespace, source.ConfigMapName, source.ConfigMapKey)
+}
+
+// configMapNamespace returns the namespace of the configMap referenced by prometheusJmxExporter.
+// PrometheusJmxExporters derived from a ClusterPrometheusJmxExporter have no default namespace.
+func configMapNamespace(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) string {
+	if len(prometheusJmxExporter.Spec.Config.ConfigMapNamespace) > 0 {
+		return prometheusJmxExporter.Spec.Config.ConfigMapNamespace
+	}
+	if prometheusJmxExporter.ClusterOwner() != nil {
+		return ""
+	}
+
+	return prometheusJmxExporter.Namespace
+}
+
+// describeConfigSource returns a human readable description of where the config is taken from
+func describeConfigSource(source *v1alpha1.ConfigSource) string {
+	if source.Inline != nil {
+		return "inline config"
+	}
+
+	if len(source.ConfigMapNamespace) > 0 {
+		return fmt.Sprintf("configMap '%s/%s:%s'", source.ConfigMapNamespace, source.ConfigMapName, source.ConfigMapKey)
+	}
+
+	return fmt.Sprintf("configMap '%s:%s'", source.ConfigMapName, source.ConfigMapKey)
+}
+
+// reloadPrometheusJmxExporterConfigs pushes the config stored in configMap to the pods of
+// all PrometheusJmxExporters that reference configMap
+func (h *Handler) reloadPrometheusJmxExporterConfigs(configMap *v1.ConfigMap) error {
+	prometheusJmxExporters, err := queryConfigMapPrometheusJmxExporters(configMap)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(prometheusJmxExporters); i++ {
+		prometheusJmxExporter := &prometheusJmxExporters[i]
+
+		if prometheusJmxExporter.DeletionTimestamp != nil ||
+			prometheusJmxExporter.Spec.Config.Inline != nil ||
+			prometheusJmxExporter.Spec.Config.ConfigMapName != configMap.Name ||
+			configMapNamespace(prometheusJmxExporter) != configMap.Namespace {
+			continue
+		}
+
+		logrus.Infof("ConfigMap '%s/%s' referenced by PrometheusJmxExporter '%s' changed",
+			configMap.Namespace,
+			configMap.Name,
+			prometheusJmxExporter.Name)
+
+		config, err := parseConfig(configMap, prometheusJmxExporter.Spec.Config.ConfigMapKey)
+		if err != nil {
+			logrus.Errorf("Error during parsing prometheus jmx exporter config: %v", err)
+			continue
+		}
+
+		podList, err := queryPrometheusJmxExporterPods(prometheusJmxExporter)
+		if err != nil {
+			logrus.Errorf("Error during querying pods : %v", err)
+			return err
+		}
+
+		h.syncPodConfigs(podList.Items, config)
+	}
+
+	return nil
+}
+
+// queryConfigMapPrometheusJmxExporters returns the PrometheusJmxExporters which may reference configMap: the ones
+// in the namespace of configMap and the ones derived from ClusterPrometheusJmxExporters in all of their namespaces
+func queryConfigMapPrometheusJmxExporters(configMap *v1.ConfigMap) ([]v1alpha1.PrometheusJmxExporter, error) {
+	prometheusJmxExporterList, err := queryPrometheusJmxExporters(configMap.Namespace)
+	if err != nil {
+		logrus.Errorf("Error during querying prometheusjmxexporters in namespace '%s': %v", configMap.Namespace, err)
+		return nil, err
+	}
+
+	var prometheusJmxExporters []v1alpha1.PrometheusJmxExporter
+	for _, prometheusJmxExporter := range prometheusJmxExporterList.Items {
+		if prometheusJmxExporter.ClusterOwner() == nil {
+			prometheusJmxExporters = append(prometheusJmxExporters, prometheusJmxExporter)
+		}
+	}
+
+	if !clusterExportersEnabled {
+		return prometheusJmxExporters, nil
+	}
+
+	clusterPrometheusJmxExporters, err := queryClusterPrometheusJmxExporters()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(clusterPrometheusJmxExporters.Items); i++ {
+		clusterPrometheusJmxExporter := &clusterPrometheusJmxExporters.Items[i]
+
+		if clusterPrometheusJmxExporter.Spec.Config.ConfigMapNamespace != configMap.Namespace {
+			continue
+		}
+
+		namespaces, err := queryClusterPrometheusJmxExporterNamespaces(clusterPrometheusJmxExporter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, namespace := range namespaces {
+			prometheusJmxExporters = append(prometheusJmxExporters, *clusterPrometheusJmxExporter.ForNamespace(namespace))
+		}
+	}
+
+	return prometheusJmxExporters, nil
+}
+
+// syncPodConfigs copies config to the containers of the already instrumented pods
+func (h *Handler) syncPodConfigs(pods []v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig) {
+	for i := 0; i < len(pods); i++ {
+		if err := h.syncPodConfig(&pods[i], config); err != nil {
+			logrus.Warnf("Reloading config of pod failed: %v", err)
+		}
+	}
+}
+
+// syncPodConfig copies config to the container of an already instrumented pod if it differs
+// from the config the pod was instrumented with. The prometheus jmx exporter agent watches the
+// modification time of its config file and reloads it on change, thus the application doesn't
+// need to be restarted.
+func (h *Handler) syncPodConfig(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig) error {
+	if pod.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerified {
+		return nil
+	}
+
+	hash, err := configHash(config)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations[prometheusJmxExporterConfigHashAnnotationKey] == hash {
+		return nil
+	}
+
+	containers := instrumentedContainers(pod)
+	for i := 0; i < len(containers); i++ {
+		container := containers[i]
+
+		logrus.Infof("Reloading prometheus jmx exporter config of '%s/%s/%s'", pod.Namespace, pod.Name, container.Name)
+
+		transfer, err := newContainerTransfer(h.executor, pod.Namespace, pod.Name, container.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := copyPrometheusJmxExporterConfToPod(h.executor, transfer, config, pod, &container); err != nil {
+			return err
+		}
+	}
+
+	if err := annotatePod(pod, map[string]string{
+		prometheusJmxExporterConfigHashAnnotationKey: hash,
+	}); err != nil {
+		return err
+	}
+
+	recordEvent(nil, pod, v1.EventTypeNormal, eventReasonConfigReloaded, "Prometheus jmx exporter config reloaded")
+
+	return nil
+}
+
+// annotateConfigHash records the hash of config on the pod
+func annotateConfigHash(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig) error {
+	hash, err := configHash(config)
+	if err != nil {
+		return err
+	}
+
+	return annotatePod(pod, map[string]string{
+		prometheusJmxExporterConfigHashAnnotationKey: hash,
+	})
+}
+
+// configHash returns the sha256 hash of the rendered config
+func configHash(config *v1alpha1.PrometheusJmxExporterConfig) (string, error) {
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(configData)
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,160 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"testing"
+)
+
+// testConfigMapData is the prometheus jmx exporter config stored in the test configMap
+const testConfigMapData = "lowercaseOutputName: true\nrules:\n- pattern: \".*\"\n"
+
+// newTestConfigMap returns the configMap holding testConfigMapData stored in api
+func newTestConfigMap(api *fakeAPI) *v1.ConfigMap {
+	configMap := &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "jmx-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{"config.yaml": testConfigMapData},
+	}
+
+	api.store("configmaps", configMap)
+
+	return configMap
+}
+
+// newInstrumentedTestPod returns a pod with the agent loaded into its app container with the config of hash stored in api
+func newInstrumentedTestPod(api *fakeAPI, name string, labels map[string]string, hash string) *v1.Pod {
+	pod := newTestPod(api, name, "app")
+	pod.Labels = labels
+	pod.Annotations = map[string]string{
+		prometheusJmxExporterAnnotationKey:           prometheusJmxExporterAnnotationVerified,
+		prometheusScrapeAnnotationKey:                "true",
+		prometheusJmxExporterEndpointsAnnotationKey:  "app/42:9020",
+		prometheusJmxExporterConfigHashAnnotationKey: hash,
+	}
+
+	api.store("pods", pod)
+
+	return pod
+}
+
+// testConfigHash returns the hash of config
+func testConfigHash(t *testing.T, config *v1alpha1.PrometheusJmxExporterConfig) string {
+	hash, err := configHash(config)
+	if err != nil {
+		t.Fatalf("hashing config failed: %v", err)
+	}
+
+	return hash
+}
+
+func TestReloadPrometheusJmxExporterConfigs(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	configMap := newTestConfigMap(api)
+	config, err := parseConfig(configMap, "config.yaml")
+	if err != nil {
+		t.Fatalf("parsing config failed: %v", err)
+	}
+
+	oldHash, newHash := testConfigHash(t, testConfig), testConfigHash(t, config)
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+	prometheusJmxExporter.Spec.Config.ConfigMapKey = "config.yaml"
+
+	// the pods of exporters with inline config are not affected by the configMap
+	inlinePrometheusJmxExporter := newTestPrometheusJmxExporter()
+	inlinePrometheusJmxExporter.Name = "inline"
+	inlinePrometheusJmxExporter.Spec.LabelSelector = map[string]string{"app": "inline"}
+	inlinePrometheusJmxExporter.Spec.Config.Inline = testConfig
+
+	stale := newInstrumentedTestPod(api, "app-1", map[string]string{"app": "test"}, oldHash)
+	upToDate := newInstrumentedTestPod(api, "app-2", map[string]string{"app": "test"}, newHash)
+	inline := newInstrumentedTestPod(api, "app-3", map[string]string{"app": "inline"}, oldHash)
+
+	installFakeCache(t, prometheusJmxExporter, inlinePrometheusJmxExporter, stale, upToDate, inline)
+
+	if err := handler.reloadPrometheusJmxExporterConfigs(configMap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	configPath := path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir, prometheusJmxExportedConfigFilename)
+	expected, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("rendering config failed: %v", err)
+	}
+
+	if copied := executor.Files[fakeContainerKey("default", "app-1", "app")][configPath]; string(copied) != string(expected) {
+		t.Errorf("expected config of the configMap copied to app-1, got '%s'", copied)
+	}
+	for _, name := range []string{"app-2", "app-3"} {
+		if commands := executor.Commands[fakeContainerKey("default", name, "app")]; len(commands) > 0 {
+			t.Errorf("expected config of %s not reloaded, got commands %v", name, commands)
+		}
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+	if hash := stored.Annotations[prometheusJmxExporterConfigHashAnnotationKey]; hash != newHash {
+		t.Errorf("expected hash of the reloaded config recorded, got '%s'", hash)
+	}
+
+	api.get(t, "pods", "default", "app-3", &stored)
+	if hash := stored.Annotations[prometheusJmxExporterConfigHashAnnotationKey]; hash != oldHash {
+		t.Errorf("expected hash of the inline config kept, got '%s'", hash)
+	}
+}
+
+func TestReloadPrometheusJmxExporterConfigsSkipsInvalidConfig(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	configMap := newTestConfigMap(api)
+	configMap.Data["config.yaml"] = "rules: {"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+	prometheusJmxExporter.Spec.Config.ConfigMapKey = "config.yaml"
+
+	pod := newInstrumentedTestPod(api, "app-1", map[string]string{"app": "test"}, testConfigHash(t, testConfig))
+
+	installFakeCache(t, prometheusJmxExporter, pod)
+
+	if err := handler.reloadPrometheusJmxExporterConfigs(configMap); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(executor.Commands) > 0 {
+		t.Errorf("expected the config the pods run with kept, got commands %v", executor.Commands)
+	}
+}
+
+func TestSyncPodConfigSkipsUnverifiedPod(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newInstrumentedTestPod(api, "app-1", map[string]string{"app": "test"}, "")
+	pod.Annotations[prometheusJmxExporterAnnotationKey] = prometheusJmxExporterAnnotationVerifiedFailed
+
+	if err := handler.syncPodConfig(pod, testConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(executor.Commands) > 0 {
+		t.Errorf("expected the config of the pod the agent failed to be loaded into not copied, got commands %v", executor.Commands)
+	}
+}
@@ -1,14 +1,50 @@
 package stub
 
 const (
-	prometheusJmxExportedConfigFilename           = "config.yaml"
-	prometheusJmxExporterTargetDir                = "/opt/jmx-exporter-loader"
-	prometheusJmxExporterTargetConfDir            = "conf"
-	prometheusJmxExporterSrcJarsDir               = "/opt/jmx-exporter-loader"
-	prometheusJmxExporterAnnotationKey            = "jmx-prometheus-exporter"
-	prometheusJmxExporterAnnotationVerified       = "verified"
-	prometheusJmxExporterAnnotationVerifiedFailed = "verified-failed"
-	prometheusJmxExporterLoaderJar                = "jmx-exporter-loader-1.0.jar"
-	prometheusJmxExporterAgentJar                 = "jmx_prometheus_javaagent-0.3.1.jar"
-	prometheusJmxExporterLoaderClass              = "com.banzaicloud.JmxExporterLoader"
+	prometheusJmxExportedConfigFilename               = "config.yaml"
+	prometheusJmxExporterTargetDir                    = "/opt/jmx-exporter-loader"
+	prometheusJmxExporterTargetConfDir                = "conf"
+	prometheusJmxExporterAnnotationKey                = "jmx-prometheus-exporter"
+	prometheusJmxExporterAnnotationVerified           = "verified"
+	prometheusJmxExporterAnnotationVerifiedFailed     = "verified-failed"
+	prometheusJmxExporterLoaderJar                    = "jmx-exporter-loader-1.0.jar"
+	prometheusJmxExporterAgentJar                     = "jmx_prometheus_javaagent-0.3.1.jar"
+	prometheusJmxExporterLoaderClass                  = "com.banzaicloud.JmxExporterLoader"
+	prometheusJmxExporterAttacherBinary               = "jmx-exporter-attach"
+	prometheusJmxExporterContainerPortName            = "jmx-metrics"
+	prometheusJmxExporterFinalizer                    = "prometheusjmxexporter.banzaicloud.com"
+	prometheusScrapeAnnotationKey                     = "prometheus.io/scrape"
+	prometheusPortAnnotationKey                       = "prometheus.io/port"
+	prometheusJmxExporterConfigHashAnnotationKey      = "jmx-prometheus-exporter/config-hash"
+	prometheusJmxExporterEndpointsAnnotationKey       = "jmx-prometheus-exporter/endpoints"
+	prometheusJmxExporterLastErrorAnnotationKey       = "jmx-prometheus-exporter/last-error"
+	prometheusJmxExporterAttachTimeAnnotationKey      = "jmx-prometheus-exporter/attach-time"
+	prometheusJmxExporterAttemptsAnnotationKey        = "jmx-prometheus-exporter/attempts"
+	prometheusJmxExporterNextRetryAnnotationKey       = "jmx-prometheus-exporter/next-retry"
+	prometheusJmxExporterContainerStatesAnnotationKey = "jmx-prometheus-exporter/container-states"
+	prometheusJmxExporterHealthAnnotationKey          = "jmx-prometheus-exporter/health"
+	prometheusJmxExporterVolumeName                   = "prometheus-jmx-exporter"
+	prometheusJmxExporterInitContainerName            = "prometheus-jmx-exporter-init"
+	prometheusJmxExporterInjectorDir                  = "/jmx-exporter-injector"
+	prometheusJmxExporterConfigVolumeName             = "prometheus-jmx-exporter-config"
+	prometheusJmxExporterInjectorConfigDir            = "/jmx-exporter-config"
+	javaToolOptionsEnvName                            = "JAVA_TOOL_OPTIONS"
 )
+
+// prometheusJmxExporterSrcJarsDir is the directory of the jars and the attacher in the image of the operator
+var prometheusJmxExporterSrcJarsDir = "/opt/jmx-exporter-loader"
+
+// podAnnotationKeys are all the annotations the operator places on the pods, these are removed when a pod is detached
+var podAnnotationKeys = []string{
+	prometheusJmxExporterAnnotationKey,
+	prometheusScrapeAnnotationKey,
+	prometheusPortAnnotationKey,
+	prometheusJmxExporterConfigHashAnnotationKey,
+	prometheusJmxExporterEndpointsAnnotationKey,
+	prometheusJmxExporterLastErrorAnnotationKey,
+	prometheusJmxExporterAttachTimeAnnotationKey,
+	prometheusJmxExporterAttemptsAnnotationKey,
+	prometheusJmxExporterNextRetryAnnotationKey,
+	prometheusJmxExporterContainerStatesAnnotationKey,
+	prometheusJmxExporterHealthAnnotationKey,
+}
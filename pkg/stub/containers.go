@@ -0,0 +1,89 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"regexp"
+)
+
+// selectContainers returns the containers of pod selected by selector. If selector is not set
+// the first container of the pod is selected.
+func selectContainers(pod *v1.Pod, selector *v1alpha1.ContainerSelector) ([]v1.Container, error) {
+	if len(pod.Spec.Containers) == 0 {
+		return nil, nil
+	}
+
+	if selector == nil {
+		return pod.Spec.Containers[:1], nil
+	}
+
+	if selector.AllJavaContainers {
+		// the containers not running java processes are filtered out later
+		return pod.Spec.Containers, nil
+	}
+
+	if len(selector.Name) > 0 {
+		for _, container := range pod.Spec.Containers {
+			if container.Name == selector.Name {
+				return []v1.Container{container}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("container '%s' not found in pod '%s/%s'", selector.Name, pod.Namespace, pod.Name)
+	}
+
+	if len(selector.NameRegex) > 0 {
+		nameRegex, err := regexp.Compile(selector.NameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid container name regex '%s': %v", selector.NameRegex, err)
+		}
+
+		var containers []v1.Container
+		for _, container := range pod.Spec.Containers {
+			if nameRegex.MatchString(container.Name) {
+				containers = append(containers, container)
+			}
+		}
+
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("no container matching '%s' found in pod '%s/%s'", selector.NameRegex, pod.Namespace, pod.Name)
+		}
+
+		return containers, nil
+	}
+
+	return pod.Spec.Containers[:1], nil
+}
+
+// instrumentedContainers returns the containers of pod into which the prometheus jmx exporter agent
+// has been loaded according to the endpoints annotation of pod
+func instrumentedContainers(pod *v1.Pod) []v1.Container {
+	if len(pod.Spec.Containers) == 0 {
+		return nil
+	}
+
+	var containers []v1.Container
+
+	for _, endpoint := range createMetricEndpoints(pod) {
+		name := endpoint.Container
+		if len(name) == 0 {
+			// pods processed by earlier versions of the operator have only the first container instrumented
+			name = pod.Spec.Containers[0].Name
+		}
+
+		if containsContainer(containers, name) {
+			// multiple java processes of the same container are instrumented
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			if container.Name == name {
+				containers = append(containers, container)
+				break
+			}
+		}
+	}
+
+	return containers
+}
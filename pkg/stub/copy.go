@@ -2,20 +2,29 @@ package stub
 
 import (
 	"archive/tar"
+	"compress/gzip"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
-	"io/ioutil"
 	"k8s.io/api/core/v1"
 	"os"
-	"path"
 	"strings"
 )
 
 // copyToPod uploads the content of srcDir to destDir on given container of the pod identified by podName
-// in namespace
-func copyToPod(namespace, podName string, container *v1.Container, srcDir, destDir string) error {
+// in namespace through executor with transfer
+func copyToPod(executor PodExecutor, namespace, podName string, container *v1.Container, transfer *containerTransfer, srcDir, destDir string) error {
 	logrus.Infof("Copying the content of '%s' directory to '%s/%s/%s:%s'", srcDir, namespace, podName, container.Name, destDir)
 
+	err := copyFiles(executor, namespace, podName, container.Name, transfer, srcDir, destDir)
+
+	logrus.Infof("Copying the content of '%s' directory to '%s/%s/%s:%s' finished", srcDir, namespace, podName, container.Name, destDir)
+	return err
+}
+
+// copyFiles uploads the content of srcDir to destDir on container through executor with the strategy of transfer.
+// The files are verified after the transfer if the tools found in the container allow.
+func copyFiles(executor PodExecutor, namespace, podName, container string, transfer *containerTransfer, srcDir, destDir string) error {
 	ok, err := checkSourceDir(srcDir)
 	if err != nil {
 		return err
@@ -30,25 +39,66 @@ func copyToPod(namespace, podName string, container *v1.Container, srcDir, destD
 		destDir = strings.TrimSuffix(destDir, "/")
 	}
 
-	err = createDestDirIfNotExists(namespace, podName, container, destDir)
+	err = createDestDirIfNotExists(executor, namespace, podName, container, destDir)
 	if err != nil {
 		logrus.Errorf("Creating destination directory failed: %v", err)
 		return err
 	}
 
+	tools := transfer.tools
+
+	files, err := sourceManifest(srcDir)
+	if err != nil {
+		return err
+	}
+
+	files, err = changedFiles(executor, namespace, podName, container, tools, files, destDir)
+	if err != nil {
+		return fmt.Errorf("querying checksums of files in container failed: %v", err)
+	}
+	if len(files) == 0 {
+		logrus.Infof("Files of '%s' are up to date in '%s/%s/%s:%s'", srcDir, namespace, podName, container, destDir)
+		return nil
+	}
+
+	switch transfer.strategy {
+	case tarTransfer:
+		err = copyWithTar(executor, namespace, podName, container, files, destDir, tools[tarGzipTool])
+	default:
+		err = copyFileByFile(executor, namespace, podName, container, transfer.strategy, files, destDir)
+	}
+	if err != nil {
+		return fmt.Errorf("%s transfer failed: %v", transfer.strategy, err)
+	}
+
+	if !tools["wc"] {
+		logrus.Warnf("wc not found in container '%s/%s/%s', the copied files are not verified", namespace, podName, container)
+	} else if err := verifyFiles(executor, namespace, podName, container, files, destDir); err != nil {
+		return fmt.Errorf("verifying files copied with %s transfer failed: %v", transfer.strategy, err)
+	}
+
+	logrus.Infof("Copied %d files to '%s/%s/%s:%s' using %s transfer", len(files), namespace, podName, container, destDir, transfer.strategy)
+	return nil
+}
+
+// copyWithTar uploads files to destDir on container through executor by extracting a tar
+// stream of them with tar inside the container. The stream is gzip compressed if compress is set.
+func copyWithTar(executor PodExecutor, namespace, podName, container string, files []sourceFile, destDir string, compress bool) error {
 	reader, writer := io.Pipe()
 	go func() {
-		defer writer.Close()
-
-		err := makeTar(srcDir, ".", writer)
+		err := makeTar(files, writer, compress)
 		if err != nil {
-			logrus.Errorf("Making tar file of '%s' failed: %v", err)
+			logrus.Errorf("Making tar file failed: %v", err)
 		}
+		writer.CloseWithError(err)
 	}()
 
-	_, err = execCommand(namespace, podName, reader, container, "tar", "xfm", "-", "-C", destDir)
+	flags := "xfm"
+	if compress {
+		flags = "xzfm"
+	}
 
-	logrus.Infof("Copying the content of '%s' directory to '%s/%s/%s:%s' finished", srcDir, namespace, podName, container.Name, destDir)
+	_, err := execWith(executor, namespace, podName, container, reader, "tar", flags, "-", "-C", destDir)
 	return err
 }
 
@@ -76,94 +126,60 @@ func checkSourceDir(path string) (bool, error) {
 
 // createDestDirIfNotExists creates the directory dirPath if not exists
 // on the target pod container
-func createDestDirIfNotExists(namespace, podName string, container *v1.Container, dirPath string) error {
-	logrus.Infof("Creating '%s/%s/%s:%s' if not exists.", namespace, podName, container.Name, dirPath)
+func createDestDirIfNotExists(executor PodExecutor, namespace, podName, container, dirPath string) error {
+	logrus.Infof("Creating '%s/%s/%s:%s' if not exists.", namespace, podName, container, dirPath)
 
-	_, err := execCommand(namespace, podName, nil, container,
+	_, err := execWith(executor, namespace, podName, container, nil,
 		"mkdir", "-p", dirPath)
 
 	return err
 }
 
-// makeTar tars the files and subdirectories of srcDir into tarDestDir (root directory within the tar file)
-// than writes the created tar file to writer
-func makeTar(srcDir, tarDestDir string, writer io.Writer) error {
-	srcDirPath := path.Clean(srcDir)
-	tarDestDirPath := path.Clean(tarDestDir)
+// makeTar tars files under their relative path than writes the created tar file, gzip compressed
+// if compress is set, to writer
+func makeTar(files []sourceFile, writer io.Writer, compress bool) error {
+	if compress {
+		gzipWriter := gzip.NewWriter(writer)
+		if err := makeTar(files, gzipWriter, false); err != nil {
+			return err
+		}
+		return gzipWriter.Close()
+	}
 
 	tarWriter := tar.NewWriter(writer)
-	defer tarWriter.Close()
 
-	return makeTarRec(srcDirPath, tarDestDirPath, tarWriter)
+	for _, file := range files {
+		if err := addFileToTar(file, tarWriter); err != nil {
+			return err
+		}
+	}
+
+	return tarWriter.Close()
 }
 
-// makeTarRec tars recursively the content of srcDirPath
-func makeTarRec(srcPath, tarDestPath string, writer *tar.Writer) error {
-	stat, err := os.Lstat(srcPath)
+// addFileToTar writes file into writer
+func addFileToTar(file sourceFile, writer *tar.Writer) error {
+	f, err := os.Open(file.localPath)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	if stat.IsDir() {
-		files, err := ioutil.ReadDir(srcPath)
-		if err != nil {
-			return err
-		}
-
-		if len(files) == 0 {
-			// empty dir
-			hdr, err := tar.FileInfoHeader(stat, srcPath)
-			if err != nil {
-				return err
-			}
-
-			hdr.Name = tarDestPath
-			if err := writer.WriteHeader(hdr); err != nil {
-				return err
-			}
-		}
-
-		for _, f := range files {
-			if err := makeTarRec(path.Join(srcPath, f.Name()), path.Join(tarDestPath, f.Name()), writer); err != nil {
-				return err
-			}
-		}
-	} else if stat.Mode()&os.ModeSymlink != 0 {
-		//case soft link
-		hdr, _ := tar.FileInfoHeader(stat, srcPath)
-		target, err := os.Readlink(srcPath)
-		if err != nil {
-			return err
-		}
-
-		hdr.Linkname = target
-		hdr.Name = tarDestPath
-		if err := writer.WriteHeader(hdr); err != nil {
-			return err
-		}
-	} else {
-		//case regular file or other file type like pipe
-		hdr, err := tar.FileInfoHeader(stat, srcPath)
-		if err != nil {
-			return err
-		}
-		hdr.Name = tarDestPath
-
-		if err := writer.WriteHeader(hdr); err != nil {
-			return err
-		}
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
 
-		f, err := os.Open(srcPath)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	hdr, err := tar.FileInfoHeader(stat, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = file.relPath
 
-		if _, err := io.Copy(writer, f); err != nil {
-			return err
-		}
-		return f.Close()
+	if err := writer.WriteHeader(hdr); err != nil {
+		return err
 	}
 
-	return nil
+	_, err = io.Copy(writer, f)
+	return err
 }
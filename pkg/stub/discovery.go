@@ -0,0 +1,225 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"path"
+	"strings"
+)
+
+// procScanScript prints the processes of the container found in /proc: their pid, executable, user id and
+// command line, and the release file of the java installation for the java executables. The accounts of
+// /etc/passwd are printed first to resolve user ids. Each line is prefixed by the kind of the information.
+const procScanScript = `{
+while read -r line; do echo "passwd $line"; done < /etc/passwd
+for dir in /proc/[0-9]*; do
+  [ -r "$dir/cmdline" ] || continue
+  exe=$(readlink "$dir/exe")
+  echo "pid ${dir#/proc/}"
+  echo "exe $exe"
+  while read -r key value rest; do
+    if [ "$key" = "Uid:" ]; then echo "uid $value"; break; fi
+  done < "$dir/status"
+  printf 'cmdline '; cat "$dir/cmdline"; echo
+  case "$exe" in
+  */bin/java)
+    home=${exe%/bin/java}
+    for release in "$home/release" "${home%/jre}/release"; do
+      if [ -r "$release" ]; then
+        while read -r line; do echo "release $line"; done < "$release"
+        break
+      fi
+    done;;
+  esac
+done
+} 2>/dev/null`
+
+// javaOptionsWithValue are the options of the java launcher followed by a separate value
+var javaOptionsWithValue = map[string]bool{
+	"-cp":                   true,
+	"-classpath":            true,
+	"--class-path":          true,
+	"-p":                    true,
+	"--module-path":         true,
+	"--upgrade-module-path": true,
+	"--add-modules":         true,
+	"--limit-modules":       true,
+	"--add-reads":           true,
+	"--add-exports":         true,
+	"--add-opens":           true,
+	"--patch-module":        true,
+}
+
+// scannedProcess is a process found in /proc of a container
+type scannedProcess struct {
+	pid     string
+	exe     string
+	uid     string
+	cmdline []string
+	release map[string]string
+}
+
+// queryJavaProcesses inspects container for running java processes. The processes are listed by jps if it's
+// available in the container. These are discovered by scanning /proc of the container if jps is not available or
+// it found none, as jps doesn't see the JVMs running with -XX:-UsePerfData, as an other user or with an other tmpdir.
+func queryJavaProcesses(executor PodExecutor, pod *v1.Pod, container *v1.Container) ([]javaProcess, error) {
+	logrus.Infof("Inspecting container '%s' for java processes", container.Name)
+
+	stdout, err := execCommand(executor, pod.Namespace, pod.Name, nil, container,
+		"sh", "-c", "$JAVA_HOME/bin/jps -lv")
+	if err == nil {
+		javaProcs := parseJpsOutput(stdout)
+		if len(javaProcs) > 0 {
+			logrus.Infof("Java processes: %s", formatJavaProcesses(javaProcs))
+
+			return javaProcs, nil
+		}
+
+		logrus.Infof("No java process found with jps, scanning /proc")
+	} else {
+		logrus.Infof("Failed to retrieve java process list with jps, scanning /proc: %v", err)
+	}
+
+	stdout, scanErr := execCommand(executor, pod.Namespace, pod.Name, nil, container, "sh", "-c", procScanScript)
+	if scanErr == nil {
+		var javaProcs []javaProcess
+		javaProcs, scanErr = parseProcScanOutput(stdout)
+		if scanErr == nil {
+			logrus.Infof("Java processes: %s", formatJavaProcesses(javaProcs))
+
+			return javaProcs, nil
+		}
+	}
+
+	if err == nil {
+		// jps ran fine, there is no java process it could see
+		logrus.Warnf("Failed to scan /proc: %v", scanErr)
+		return nil, nil
+	}
+
+	logrus.Warnf("Failed to retrieve java process list: %v", scanErr)
+	return nil, fmt.Errorf("listing java processes failed, jps: %v, /proc: %v", err, scanErr)
+}
+
+// parseProcScanOutput returns the java processes from the output of procScanScript. Lines not matching the expected
+// format are skipped, an error is returned only if no process could be found at all.
+func parseProcScanOutput(output string) ([]javaProcess, error) {
+	users := make(map[string]string)
+	var procs []*scannedProcess
+
+	for _, line := range strings.Split(output, "\n") {
+		kind, value := splitScanLine(line)
+
+		if kind == "passwd" {
+			// name:password:uid:gid:...
+			if fields := strings.Split(value, ":"); len(fields) > 2 {
+				users[fields[2]] = fields[0]
+			}
+			continue
+		}
+
+		if kind == "pid" {
+			procs = append(procs, &scannedProcess{pid: value, release: make(map[string]string)})
+			continue
+		}
+
+		if len(procs) == 0 {
+			continue
+		}
+		proc := procs[len(procs)-1]
+
+		switch kind {
+		case "exe":
+			proc.exe = value
+		case "uid":
+			proc.uid = value
+		case "cmdline":
+			proc.cmdline = strings.FieldsFunc(value, func(r rune) bool { return r == 0 })
+		case "release":
+			if i := strings.Index(value, "="); i > 0 {
+				proc.release[value[:i]] = strings.Trim(value[i+1:], `"`)
+			}
+		}
+	}
+
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("no process found in /proc")
+	}
+
+	var javaProcs []javaProcess
+	for _, proc := range procs {
+		if !isJavaExecutable(proc) {
+			continue
+		}
+
+		javaProc := javaProcess{Pid: proc.pid}
+		javaProc.MainClass, javaProc.JvmArgs = parseJavaCommandLine(proc.cmdline)
+		if isJps(javaProc.MainClass) {
+			continue
+		}
+
+		javaProc.Version = proc.release["JAVA_VERSION"]
+		javaProc.Vendor = proc.release["IMPLEMENTOR"]
+		if len(javaProc.Vendor) == 0 {
+			javaProc.Vendor = proc.release["JAVA_VENDOR"]
+		}
+
+		javaProc.User = proc.uid
+		if name, ok := users[proc.uid]; ok {
+			javaProc.User = name
+		}
+
+		javaProcs = append(javaProcs, javaProc)
+	}
+
+	return javaProcs, nil
+}
+
+// splitScanLine splits line of the output of procScanScript into the kind of the information and its value
+func splitScanLine(line string) (string, string) {
+	i := strings.Index(line, " ")
+	if i < 0 {
+		return line, ""
+	}
+
+	return line[:i], line[i+1:]
+}
+
+// isJavaExecutable returns true if proc runs the java launcher. If the executable can't be read due to
+// missing permissions the program of the command line is checked.
+func isJavaExecutable(proc *scannedProcess) bool {
+	if len(proc.exe) > 0 {
+		return path.Base(proc.exe) == "java"
+	}
+
+	return len(proc.cmdline) > 0 && path.Base(proc.cmdline[0]) == "java"
+}
+
+// parseJavaCommandLine returns the main class or jar file and the JVM arguments of the java launcher command line args,
+// similarly to 'jps -lv'. The main class is empty if it can't be determined.
+func parseJavaCommandLine(args []string) (string, []string) {
+	var jvmArgs []string
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "-jar" || arg == "-m" || arg == "--module":
+			if i+1 < len(args) {
+				return args[i+1], jvmArgs
+			}
+			return "", jvmArgs
+		case strings.HasPrefix(arg, "--module="):
+			return strings.TrimPrefix(arg, "--module="), jvmArgs
+		case javaOptionsWithValue[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+			jvmArgs = append(jvmArgs, arg)
+		default:
+			return arg, jvmArgs
+		}
+	}
+
+	return "", jvmArgs
+}
@@ -0,0 +1,181 @@
+package stub
+
+import (
+	"k8s.io/api/core/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// procScanOutput returns the output of procScanScript listing the given processes in 'pid exe uid cmdline' form,
+// the arguments of cmdline are separated by spaces
+func procScanOutput(procs ...[4]string) string {
+	lines := []string{
+		"passwd root:x:0:0:root:/root:/bin/sh",
+		"passwd app:x:1000:1000::/home/app:/bin/sh",
+	}
+
+	for _, proc := range procs {
+		lines = append(lines,
+			"pid "+proc[0],
+			"exe "+proc[1],
+			"uid "+proc[2],
+			"cmdline "+strings.Replace(proc[3], " ", "\x00", -1)+"\x00")
+
+		if strings.HasSuffix(proc[1], "/bin/java") {
+			lines = append(lines,
+				`release JAVA_VERSION="1.8.0_171"`,
+				`release IMPLEMENTOR="Oracle Corporation"`)
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestParseJpsOutput(t *testing.T) {
+	output := strings.Join([]string{
+		"42 com.example.App -Xmx256m -Dapp.name=test",
+		"43 -- process information unavailable",
+		"44 /app/worker.jar",
+		"45 sun.tools.jps.Jps -Dapplication.home=/usr/lib/jvm/java-8-openjdk-amd64 -Xms8m",
+		"Picked up JAVA_TOOL_OPTIONS: -Xss512k",
+	}, "\n")
+
+	expected := []javaProcess{
+		{Pid: "42", MainClass: "com.example.App", JvmArgs: []string{"-Xmx256m", "-Dapp.name=test"}},
+		{Pid: "43"},
+		{Pid: "44", MainClass: "/app/worker.jar", JvmArgs: []string{}},
+	}
+
+	if procs := parseJpsOutput(output); !reflect.DeepEqual(procs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, procs)
+	}
+}
+
+func TestParseProcScanOutput(t *testing.T) {
+	output := procScanOutput(
+		[4]string{"1", "/bin/sh", "0", "/bin/sh -c java -jar /app.jar"},
+		[4]string{"7", "/usr/lib/jvm/java-8-openjdk-amd64/jre/bin/java", "1000", "java -Xmx256m -cp /app/lib com.example.App --port 8080"},
+		[4]string{"8", "/usr/lib/jvm/java-8-openjdk-amd64/jre/bin/java", "1234", "java -Xms8m -jar /app/worker.jar"},
+	)
+
+	procs, err := parseProcScanOutput(output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []javaProcess{
+		{
+			Pid:       "7",
+			MainClass: "com.example.App",
+			JvmArgs:   []string{"-Xmx256m"},
+			Vendor:    "Oracle Corporation",
+			Version:   "1.8.0_171",
+			User:      "app",
+		},
+		{
+			Pid:       "8",
+			MainClass: "/app/worker.jar",
+			JvmArgs:   []string{"-Xms8m"},
+			Vendor:    "Oracle Corporation",
+			Version:   "1.8.0_171",
+			User:      "1234",
+		},
+	}
+
+	if !reflect.DeepEqual(procs, expected) {
+		t.Errorf("expected %+v, got %+v", expected, procs)
+	}
+}
+
+func TestParseProcScanOutputWithoutProcesses(t *testing.T) {
+	if _, err := parseProcScanOutput("passwd root:x:0:0:root:/root:/bin/sh\n"); err == nil {
+		t.Error("expected error for output without processes")
+	}
+}
+
+func TestQueryJavaProcesses(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "app-1"
+	container := &v1.Container{Name: "app"}
+	key := fakeContainerKey("default", "app-1", "app")
+
+	scan := procScanOutput(
+		[4]string{"7", "/usr/lib/jvm/java-8-openjdk-amd64/jre/bin/java", "1000", "java -Xmx256m com.example.App"},
+	)
+	scanned := []javaProcess{
+		{Pid: "7", MainClass: "com.example.App", JvmArgs: []string{"-Xmx256m"}, Vendor: "Oracle Corporation", Version: "1.8.0_171", User: "app"},
+	}
+
+	tests := []struct {
+		name     string
+		jps      *string
+		procScan string
+		expected []javaProcess
+		scanned  bool
+	}{
+		{
+			name:     "jps",
+			jps:      stringPtr("7 com.example.App -Xmx256m\n"),
+			procScan: scan,
+			expected: []javaProcess{{Pid: "7", MainClass: "com.example.App", JvmArgs: []string{"-Xmx256m"}}},
+		},
+		{
+			name:     "no jps",
+			procScan: scan,
+			expected: scanned,
+			scanned:  true,
+		},
+		{
+			name:     "jps sees no jvm",
+			jps:      stringPtr("12 sun.tools.jps.Jps -Dapplication.home=/usr/lib/jvm/java-8-openjdk-amd64 -Xms8m\n"),
+			procScan: scan,
+			expected: scanned,
+			scanned:  true,
+		},
+		{
+			name:     "no jvm at all",
+			jps:      stringPtr(""),
+			procScan: procScanOutput([4]string{"1", "/bin/sh", "0", "/bin/sh"}),
+			expected: []javaProcess{},
+			scanned:  true,
+		},
+	}
+
+	for _, test := range tests {
+		executor := newFakePodExecutor()
+		if test.jps != nil {
+			executor.JpsOutput[key] = *test.jps
+		}
+		executor.ProcOutput[key] = test.procScan
+
+		procs, err := queryJavaProcesses(executor, pod, container)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if len(procs) != len(test.expected) || (len(procs) > 0 && !reflect.DeepEqual(procs, test.expected)) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, procs)
+		}
+
+		commands := executor.Commands[key]
+		if scanned := commands[len(commands)-1] == "sh -c "+procScanScript; scanned != test.scanned {
+			t.Errorf("%s: expected /proc scanned %v, got %v", test.name, test.scanned, commands)
+		}
+	}
+}
+
+func TestQueryJavaProcessesFails(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "app-1"
+
+	if _, err := queryJavaProcesses(newFakePodExecutor(), pod, &v1.Container{Name: "app"}); err == nil {
+		t.Error("expected error without jps and java processes in /proc")
+	}
+}
+
+// stringPtr returns a pointer to s
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,97 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// reasons of the events emitted on PrometheusJmxExporters and pods
+const (
+	eventReasonConfigLoadFailed        = "ConfigLoadFailed"
+	eventReasonSelectorConflict        = "SelectorConflict"
+	eventReasonContainerNotFound       = "ContainerNotFound"
+	eventReasonJavaProcessNotFound     = "JavaProcessNotFound"
+	eventReasonMultipleJavaProcesses   = "MultipleJavaProcesses"
+	eventReasonPortConflict            = "PortConflict"
+	eventReasonJmxExporterAttached     = "JmxExporterAttached"
+	eventReasonJmxExporterAttachFailed = "JmxExporterAttachFailed"
+	eventReasonJmxExporterDetached     = "JmxExporterDetached"
+	eventReasonConfigReloaded          = "ConfigReloaded"
+	eventReasonMonitorSyncFailed       = "MonitorSyncFailed"
+	eventReasonRetriesExhausted        = "RetriesExhausted"
+	eventReasonContainerRestarted      = "ContainerRestarted"
+	eventReasonEndpointDisappeared     = "EndpointDisappeared"
+	eventReasonFileTransferSelected    = "FileTransferSelected"
+)
+
+const eventRecorderComponent = "prometheus-jmx-exporter-operator"
+
+var eventRecorder record.EventRecorder
+
+// newEventRecorder returns an event recorder which posts the events through kubeClient
+func newEventRecorder(kubeClient kubernetes.Interface) (record.EventRecorder, error) {
+	eventScheme := runtime.NewScheme()
+	scheme.AddToScheme(eventScheme)
+	if err := v1alpha1.AddToScheme(eventScheme); err != nil {
+		return nil, fmt.Errorf("registering prometheusjmxexporter types for events failed: %v", err)
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logrus.Debugf)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(eventScheme, v1.EventSource{Component: eventRecorderComponent}), nil
+}
+
+// recordEvent emits an event of eventType on both prometheusJmxExporter and pod. Either of them can be nil.
+func recordEvent(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, pod *v1.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	if eventRecorder == nil {
+		return
+	}
+
+	if prometheusJmxExporter != nil {
+		target := eventTarget(prometheusJmxExporter)
+
+		if pod != nil {
+			eventRecorder.Eventf(target, eventType, reason, "Pod '%s/%s': "+messageFmt, append([]interface{}{pod.Namespace, pod.Name}, args...)...)
+		} else {
+			eventRecorder.Eventf(target, eventType, reason, messageFmt, args...)
+		}
+	}
+
+	if pod != nil {
+		eventRecorder.Eventf(pod, eventType, reason, messageFmt, args...)
+	}
+}
+
+// recordClusterEvent emits an event of eventType on clusterPrometheusJmxExporter
+func recordClusterEvent(clusterPrometheusJmxExporter *v1alpha1.ClusterPrometheusJmxExporter, eventType, reason, messageFmt string, args ...interface{}) {
+	if eventRecorder == nil {
+		return
+	}
+
+	eventRecorder.Eventf(clusterPrometheusJmxExporter, eventType, reason, messageFmt, args...)
+}
+
+// eventTarget returns the object the events of prometheusJmxExporter are recorded on. PrometheusJmxExporters
+// derived from a ClusterPrometheusJmxExporter are not stored, their events are recorded on the ClusterPrometheusJmxExporter.
+func eventTarget(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) runtime.Object {
+	clusterOwner := prometheusJmxExporter.ClusterOwner()
+	if clusterOwner == nil {
+		return prometheusJmxExporter
+	}
+
+	return &v1.ObjectReference{
+		APIVersion: clusterOwner.APIVersion,
+		Kind:       clusterOwner.Kind,
+		Name:       clusterOwner.Name,
+		UID:        clusterOwner.UID,
+	}
+}
@@ -1,80 +1,27 @@
 package stub
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io"
 	"k8s.io/api/core/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
-	"net"
-	"os"
+	"strings"
 )
 
-var (
-	kubeClient      *kubernetes.Clientset
-	inClusterConfig *rest.Config
-)
-
-func init() {
-	// Work around https://github.com/kubernetes/kubernetes/issues/40973
-	// See https://github.com/coreos/etcd-operator/issues/731#issuecomment-283804819
-	if len(os.Getenv("KUBERNETES_SERVICE_HOST")) == 0 {
-		addrs, err := net.LookupHost("kubernetes.default.svc")
-		if err != nil {
-			panic(err)
-		}
-		os.Setenv("KUBERNETES_SERVICE_HOST", addrs[0])
-	}
-	if len(os.Getenv("KUBERNETES_SERVICE_PORT")) == 0 {
-		os.Setenv("KUBERNETES_SERVICE_PORT", "443")
-	}
-
-	var err error
-	inClusterConfig, err = rest.InClusterConfig()
-
-	if err != nil {
-		panic(err)
-	}
-
-	kubeClient = kubernetes.NewForConfigOrDie(inClusterConfig)
+// execCommand executes the given command inside the specified container remotely through executor
+func execCommand(executor PodExecutor, namespace, podName string, stdinReader io.Reader, container *v1.Container, command ...string) (string, error) {
+	return execWith(executor, namespace, podName, container.Name, stdinReader, command...)
 }
 
-// execCommand executes the given command inside the specified container remotely
-func execCommand(namespace, podName string, stdinReader io.Reader, container *v1.Container, command ...string) (string, error) {
-
-	execReq := kubeClient.CoreV1().RESTClient().Post()
-	execReq = execReq.Resource("pods").Name(podName).Namespace(namespace).SubResource("exec")
-
-	execReq.VersionedParams(&v1.PodExecOptions{
-		Container: container.Name,
-		Command:   command,
-		Stdout:    true,
-		Stderr:    true,
-		Stdin:     stdinReader != nil,
-	}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(inClusterConfig, "POST", execReq.URL())
-
-	if err != nil {
-		logrus.Errorf("Creating remote command executor failed: %v", err)
-		return "", err
-	}
-
+// execWith executes command inside container through executor. Returns the output of the command,
+// the command is considered failed if it writes to stderr.
+func execWith(executor PodExecutor, namespace, podName, container string, stdinReader io.Reader, command ...string) (string, error) {
 	stdOut := bytes.Buffer{}
 	stdErr := bytes.Buffer{}
 
-	logrus.Debugf("Executing command '%v' in namespace='%s', pod='%s', container='%s'", command, namespace, podName, container.Name)
-	err = exec.Stream(remotecommand.StreamOptions{
-		Stdout: bufio.NewWriter(&stdOut),
-		Stderr: bufio.NewWriter(&stdErr),
-		Stdin:  stdinReader,
-		Tty:    false,
-	})
+	logrus.Debugf("Executing command '%v' in namespace='%s', pod='%s', container='%s'", command, namespace, podName, container)
+	err := executor.Exec(namespace, podName, container, stdinReader, &stdOut, &stdErr, command...)
 
 	logrus.Debugf("Command stderr: %s", stdErr.String())
 	logrus.Debugf("Command stdout: %s", stdOut.String())
@@ -82,6 +29,9 @@ func execCommand(namespace, podName string, stdinReader io.Reader, container *v1
 	if err != nil {
 		logrus.Infof("Executing command failed with: %v", err)
 
+		if stdErr.Len() > 0 {
+			return "", fmt.Errorf("%v, stderr: %s", err, strings.TrimSpace(stdErr.String()))
+		}
 		return "", err
 	}
 
@@ -91,5 +41,4 @@ func execCommand(namespace, podName string, stdinReader io.Reader, container *v1
 	}
 
 	return stdOut.String(), nil
-
 }
@@ -0,0 +1,62 @@
+package stub
+
+import (
+	"io"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodExecutor runs commands in the containers of pods
+type PodExecutor interface {
+	// Exec runs command in container of the pod identified by namespace and podName. The command reads stdin if
+	// it's not nil, its output is written to stdout and stderr.
+	Exec(namespace, podName, container string, stdin io.Reader, stdout, stderr io.Writer, command ...string) error
+}
+
+// spdyExecutor runs the commands through the exec subresource of the pods
+type spdyExecutor struct {
+	client kubernetes.Interface
+	config *rest.Config
+}
+
+// NewSPDYExecutor returns a PodExecutor which runs the commands through the exec API of the pods over SPDY
+func NewSPDYExecutor(config *rest.Config) (PodExecutor, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spdyExecutor{
+		client: client,
+		config: config,
+	}, nil
+}
+
+// Exec runs command in container through the exec subresource of the pod
+func (e *spdyExecutor) Exec(namespace, podName, container string, stdin io.Reader, stdout, stderr io.Writer, command ...string) error {
+	execReq := e.client.CoreV1().RESTClient().Post()
+	execReq = execReq.Resource("pods").Name(podName).Namespace(namespace).SubResource("exec")
+
+	execReq.VersionedParams(&v1.PodExecOptions{
+		Container: container,
+		Command:   command,
+		Stdout:    true,
+		Stderr:    true,
+		Stdin:     stdin != nil,
+	}, scheme.ParameterCodec)
+
+	exec, err := remotecommand.NewSPDYExecutor(e.config, "POST", execReq.URL())
+	if err != nil {
+		return err
+	}
+
+	return exec.Stream(remotecommand.StreamOptions{
+		Stdout: stdout,
+		Stderr: stderr,
+		Stdin:  stdin,
+		Tty:    false,
+	})
+}
@@ -0,0 +1,155 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sync"
+	"testing"
+)
+
+// fakeAPI is an in-memory API server the resources are read and written through in place of the dynamic clients
+type fakeAPI struct {
+	mutex sync.Mutex
+
+	// objects are the stored objects by resource, namespace and name
+	objects map[string]*unstructured.Unstructured
+	// updates counts the updates by resource, namespace and name
+	updates map[string]int
+	// conflicts is the number of updates failing with conflict by resource, namespace and name
+	conflicts map[string]int
+}
+
+// installFakeAPI makes the operator read and write pods, configmaps, secrets and prometheusjmxexporters through a new fakeAPI
+func installFakeAPI() *fakeAPI {
+	api := &fakeAPI{
+		objects:   make(map[string]*unstructured.Unstructured),
+		updates:   make(map[string]int),
+		conflicts: make(map[string]int),
+	}
+
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Namespaced: true, Kind: "Pod"},
+				{Name: "configmaps", Namespaced: true, Kind: "ConfigMap"},
+				{Name: "secrets", Namespaced: true, Kind: "Secret"},
+			},
+		},
+		{
+			GroupVersion: "banzaicloud.com/v1alpha1",
+			APIResources: []metav1.APIResource{
+				{Name: "prometheusjmxexporters", Namespaced: true, Kind: "PrometheusJmxExporter"},
+				{Name: "clusterprometheusjmxexporters", Namespaced: false, Kind: "ClusterPrometheusJmxExporter"},
+			},
+		},
+	}
+	restMapper = discovery.NewDeferredDiscoveryRESTMapper(cached.NewMemCacheClient(discoveryClient), meta.InterfacesForUnstructured)
+	restMapper.Reset()
+
+	pool := &fakedynamic.FakeClientPool{}
+	pool.AddReactor("*", "*", api.react)
+	clientPool = pool
+
+	eventRecorder = nil
+	informers = nil
+
+	return api
+}
+
+// react serves action from the stored objects
+func (a *fakeAPI) react(action clienttesting.Action) (bool, runtime.Object, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	resource := action.GetResource()
+
+	switch action.GetVerb() {
+	case "get":
+		name := action.(clienttesting.GetAction).GetName()
+		obj, ok := a.objects[fakeObjectKey(resource.Resource, action.GetNamespace(), name)]
+		if !ok {
+			return true, nil, apierrors.NewNotFound(resource.GroupResource(), name)
+		}
+		return true, obj.DeepCopy(), nil
+	case "create":
+		obj := action.(clienttesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		key := fakeObjectKey(resource.Resource, obj.GetNamespace(), obj.GetName())
+		if _, ok := a.objects[key]; ok {
+			return true, nil, apierrors.NewAlreadyExists(resource.GroupResource(), obj.GetName())
+		}
+		a.objects[key] = obj
+		return true, obj.DeepCopy(), nil
+	case "update":
+		obj := action.(clienttesting.UpdateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+		key := fakeObjectKey(resource.Resource, obj.GetNamespace(), obj.GetName())
+		if a.conflicts[key] > 0 {
+			a.conflicts[key]--
+			return true, nil, apierrors.NewConflict(resource.GroupResource(), obj.GetName(), fmt.Errorf("the object has been modified"))
+		}
+		a.objects[key] = obj
+		a.updates[key]++
+		return true, obj.DeepCopy(), nil
+	case "delete":
+		delete(a.objects, fakeObjectKey(resource.Resource, action.GetNamespace(), action.(clienttesting.DeleteAction).GetName()))
+		return true, nil, nil
+	}
+
+	return false, nil, nil
+}
+
+// store stores object as if it was created through the API server
+func (a *fakeAPI) store(resource string, object runtime.Object) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	u := k8sutil.UnstructuredFromRuntimeObject(object)
+	a.objects[fakeObjectKey(resource, u.GetNamespace(), u.GetName())] = u
+}
+
+// get reads the stored object of resource identified by namespace and name into into
+func (a *fakeAPI) get(t *testing.T, resource, namespace, name string, into runtime.Object) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	u, ok := a.objects[fakeObjectKey(resource, namespace, name)]
+	if !ok {
+		t.Fatalf("%s '%s/%s' not found", resource, namespace, name)
+	}
+
+	if err := k8sutil.UnstructuredIntoRuntimeObject(u, into); err != nil {
+		t.Fatalf("decoding %s '%s/%s' failed: %v", resource, namespace, name, err)
+	}
+}
+
+// updateCount returns the number of updates of the object of resource identified by namespace and name
+func (a *fakeAPI) updateCount(resource, namespace, name string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.updates[fakeObjectKey(resource, namespace, name)]
+}
+
+// conflict makes the next count updates of the object of resource identified by namespace and name fail with conflict
+func (a *fakeAPI) conflict(resource, namespace, name string, count int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.conflicts[fakeObjectKey(resource, namespace, name)] = count
+}
+
+// fakeObjectKey returns the key of an object in the maps of fakeAPI
+func fakeObjectKey(resource, namespace, name string) string {
+	return resource + "/" + namespace + "/" + name
+}
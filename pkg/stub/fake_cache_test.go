@@ -0,0 +1,42 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+	"testing"
+)
+
+// installFakeCache makes the operator read the pods and prometheusjmxexporters of all namespaces from caches
+// holding objects in place of the informers, which are not run in the tests
+func installFakeCache(t *testing.T, objects ...runtime.Object) {
+	nsInformers := &namespaceInformers{
+		pods: cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.Pod{}, 0, cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			labelIndex:           podLabelIndexFunc,
+		}),
+		prometheusJmxExporters: cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1alpha1.PrometheusJmxExporter{}, 0, cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			selectorIndex:        prometheusJmxExporterSelectorIndexFunc,
+		}),
+	}
+
+	for _, object := range objects {
+		var err error
+		switch o := object.(type) {
+		case *v1.Pod:
+			err = nsInformers.pods.GetIndexer().Add(o.DeepCopy())
+		case *v1alpha1.PrometheusJmxExporter:
+			err = nsInformers.prometheusJmxExporters.GetIndexer().Add(o.DeepCopy())
+		default:
+			t.Fatalf("unexpected object type %T", object)
+		}
+		if err != nil {
+			t.Fatalf("caching %T failed: %v", object, err)
+		}
+	}
+
+	informers = map[string]*namespaceInformers{metav1.NamespaceAll: nsInformers}
+}
@@ -0,0 +1,268 @@
+package stub
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMain runs the tests with the jars and the attacher of the operator image replaced by fake files
+func TestMain(m *testing.M) {
+	srcDir, err := ioutil.TempDir("", "jmx-exporter-loader")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "creating fake jars dir failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	fakeFiles := map[string]os.FileMode{
+		prometheusJmxExporterLoaderJar:      0644,
+		prometheusJmxExporterAgentJar:       0644,
+		prometheusJmxExporterAttacherBinary: 0755,
+	}
+	for name, mode := range fakeFiles {
+		if err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte("fake "+name), mode); err != nil {
+			fmt.Fprintf(os.Stderr, "creating fake %s failed: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+	prometheusJmxExporterSrcJarsDir = srcDir
+
+	code := m.Run()
+
+	os.RemoveAll(srcDir)
+	os.Exit(code)
+}
+
+// loaderArgsRegexp matches the pid and port arguments of the prometheus jmx exporter loader command
+var loaderArgsRegexp = regexp.MustCompile(`-Dpid=(\S+).*-Dprometheus\.port=(\d+)`)
+
+// fakePodExecutor is an in-memory PodExecutor which simulates the commands the operator runs in the containers:
+// jps, the /proc scan, cat /proc/net/tcp, mkdir, tar, rm, the prometheus jmx exporter loader, the native attacher and
+// the tool probe, upload and checksum commands of the file transfers. The containers are identified by
+// the keys returned by fakeContainerKey.
+type fakePodExecutor struct {
+	mutex sync.Mutex
+
+	// JpsOutput is the output of 'jps -lv' by container, jps is not found in the containers missing from it
+	JpsOutput map[string]string
+	// ProcOutput is the output of the /proc scan discovering java processes by container
+	ProcOutput map[string]string
+	// ProcNetTcp is the content of /proc/net/tcp by container
+	ProcNetTcp map[string]string
+	// Errors makes the commands with the given program name (jps, proc, cat, mkdir, tar, rm, java,
+	// jmx-exporter-attach, probe, base64, dd, chmod, sums) fail with the error
+	Errors map[string]error
+	// Stderr makes the commands with the given program name write the message to stderr
+	Stderr map[string]string
+	// MissingTools are the tools the tool probe doesn't report as found in the containers, tar-z makes tar lack gzip support
+	MissingTools map[string]bool
+	// Corrupt are the paths of the files whose content is altered when they are copied into the containers
+	Corrupt map[string]bool
+
+	// Dirs are the directories created by container
+	Dirs map[string][]string
+	// Files are the contents of the files copied into the containers by container and path
+	Files map[string]map[string][]byte
+	// LoadedAgents are the agents loaded by container in <pid>:<port> format
+	LoadedAgents map[string][]string
+	// Commands are all the commands executed by container
+	Commands map[string][]string
+}
+
+// newFakePodExecutor returns a fakePodExecutor with no java processes running in the containers
+func newFakePodExecutor() *fakePodExecutor {
+	return &fakePodExecutor{
+		JpsOutput:    make(map[string]string),
+		ProcOutput:   make(map[string]string),
+		ProcNetTcp:   make(map[string]string),
+		Errors:       make(map[string]error),
+		Stderr:       make(map[string]string),
+		MissingTools: make(map[string]bool),
+		Corrupt:      make(map[string]bool),
+		Dirs:         make(map[string][]string),
+		Files:        make(map[string]map[string][]byte),
+		LoadedAgents: make(map[string][]string),
+		Commands:     make(map[string][]string),
+	}
+}
+
+// fakeContainerKey returns the key of container of the pod identified by namespace and podName in the maps of fakePodExecutor
+func fakeContainerKey(namespace, podName, container string) string {
+	return namespace + "/" + podName + "/" + container
+}
+
+// Exec simulates command in container
+func (e *fakePodExecutor) Exec(namespace, podName, container string, stdin io.Reader, stdout, stderr io.Writer, command ...string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	key := fakeContainerKey(namespace, podName, container)
+	e.Commands[key] = append(e.Commands[key], strings.Join(command, " "))
+
+	program, args := fakeProgram(command)
+
+	if err, ok := e.Errors[program]; ok {
+		return err
+	}
+	if message, ok := e.Stderr[program]; ok {
+		io.WriteString(stderr, message)
+		return nil
+	}
+
+	switch program {
+	case "jps":
+		output, ok := e.JpsOutput[key]
+		if !ok {
+			io.WriteString(stderr, "sh: jps: not found")
+			return nil
+		}
+		io.WriteString(stdout, output)
+	case "proc":
+		io.WriteString(stdout, e.ProcOutput[key])
+	case "cat":
+		io.WriteString(stdout, e.ProcNetTcp[key])
+	case "mkdir":
+		e.Dirs[key] = append(e.Dirs[key], args[len(args)-1])
+	case "rm":
+		dir := args[len(args)-1]
+		for file := range e.Files[key] {
+			if strings.HasPrefix(file, dir+"/") {
+				delete(e.Files[key], file)
+			}
+		}
+	case "tar":
+		if stdin == nil {
+			return fmt.Errorf("tar: no input")
+		}
+		if strings.Contains(args[0], "z") {
+			gzipReader, err := gzip.NewReader(stdin)
+			if err != nil {
+				return err
+			}
+			stdin = gzipReader
+		}
+		return e.extractTar(key, stdin, args[len(args)-1])
+	case "probe":
+		for _, tool := range []string{"tar", "base64", "dd", "wc", "sha256sum", tarGzipTool} {
+			if !e.MissingTools[tool] {
+				io.WriteString(stdout, tool+"\n")
+			}
+		}
+	case "base64", "dd":
+		if stdin == nil {
+			return fmt.Errorf("%s: no input", program)
+		}
+		if program == "base64" {
+			stdin = base64.NewDecoder(base64.StdEncoding, stdin)
+		}
+		content, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		e.storeFile(key, args[0], content)
+	case "chmod":
+	case "sums":
+		for _, file := range args {
+			content, ok := e.Files[key][file]
+			if !ok {
+				continue
+			}
+			sum := sha256.Sum256(content)
+			fmt.Fprintf(stdout, "%d %s %s\n", len(content), hex.EncodeToString(sum[:]), file)
+		}
+	case "java":
+		match := loaderArgsRegexp.FindStringSubmatch(strings.Join(args, " "))
+		if match == nil {
+			return fmt.Errorf("java: unexpected arguments %v", args)
+		}
+		e.LoadedAgents[key] = append(e.LoadedAgents[key], match[1]+":"+match[2])
+	case prometheusJmxExporterAttacherBinary:
+		// <pid> <agent jar> <port>:<config path>
+		if len(args) != 3 || !strings.Contains(args[2], ":") {
+			return fmt.Errorf("%s: unexpected arguments %v", program, args)
+		}
+		e.LoadedAgents[key] = append(e.LoadedAgents[key], args[0]+":"+strings.SplitN(args[2], ":", 2)[0])
+	default:
+		return fmt.Errorf("%s: command not found", program)
+	}
+
+	return nil
+}
+
+// extractTar stores the files of the tar stream read from reader under destDir of the container identified by key
+func (e *fakePodExecutor) extractTar(key string, reader io.Reader, destDir string) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+
+		e.storeFile(key, path.Join(destDir, hdr.Name), content)
+	}
+}
+
+// storeFile stores content as the file filePath of the container identified by key
+func (e *fakePodExecutor) storeFile(key, filePath string, content []byte) {
+	if e.Files[key] == nil {
+		e.Files[key] = make(map[string][]byte)
+	}
+
+	if e.Corrupt[filePath] {
+		content = append(content, '\n')
+	}
+
+	e.Files[key][filePath] = content
+}
+
+// fakeProgram returns the name of the program command runs and its arguments. Shell scripts
+// run through 'sh -c' are split into words, $JAVA_HOME/bin/ is stripped from the program. The scripts of the operator are
+// named after their purpose: proc is the /proc scan, probe is the tool probe and sums is the checksum script.
+func fakeProgram(command []string) (string, []string) {
+	if len(command) >= 3 && command[0] == "sh" && command[1] == "-c" {
+		switch command[2] {
+		case procScanScript:
+			return "proc", nil
+		case toolProbeScript:
+			return "probe", nil
+		case fileSumScript:
+			// the first argument is $0
+			return "sums", command[4:]
+		case base64UploadScript:
+			return "base64", command[3:]
+		case ddUploadScript:
+			return "dd", command[3:]
+		}
+
+		command = strings.Fields(command[2])
+	}
+	if len(command) == 0 {
+		return "", nil
+	}
+
+	return path.Base(command[0]), command[1:]
+}
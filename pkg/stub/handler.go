@@ -4,28 +4,30 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
-	"github.com/operator-framework/operator-sdk/pkg/sdk/action"
-	"github.com/operator-framework/operator-sdk/pkg/sdk/handler"
-	"github.com/operator-framework/operator-sdk/pkg/sdk/query"
-	"github.com/operator-framework/operator-sdk/pkg/sdk/types"
 	"github.com/ghodss/yaml"
+	"github.com/operator-framework/operator-sdk/pkg/sdk/types"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
 	"os"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func NewHandler() handler.Handler {
-	return &Handler{}
+// NewHandler returns the handler of the operator which runs the commands in the containers through executor
+func NewHandler(executor PodExecutor) *Handler {
+	return &Handler{executor: executor}
 }
 
+// Handler reconciles PrometheusJmxExporters and instruments the pods selected by them
 type Handler struct {
-	// Fill me
+	// executor runs the commands of the operator in the containers
+	executor PodExecutor
 }
 
 func (h *Handler) Handle(ctx types.Context, event types.Event) error {
@@ -46,28 +48,61 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			return nil
 		}
 
-		logrus.Infof(
-			"Retrieving prometheus jmx exporter config from configMap '%s/%s:%s'",
-			prometheusJmxExporter.Namespace,
-			prometheusJmxExporter.Spec.Config.ConfigMapName,
-			prometheusJmxExporter.Spec.Config.ConfigMapKey)
+		if prometheusJmxExporter.DeletionTimestamp != nil {
+			logrus.Infof(
+				"PrometheusJmxExporter '%s/%s' is being deleted",
+				prometheusJmxExporter.Namespace,
+				prometheusJmxExporter.Name)
 
-		config, err := getConfig(
-			prometheusJmxExporter.Namespace,
-			prometheusJmxExporter.Spec.Config.ConfigMapName,
-			prometheusJmxExporter.Spec.Config.ConfigMapKey)
+			if hasFinalizer(prometheusJmxExporter) {
+				return h.cleanupPrometheusJmxExporter(prometheusJmxExporter)
+			}
+
+			return nil
+		}
+
+		if !hasFinalizer(prometheusJmxExporter) {
+			if err := addFinalizer(prometheusJmxExporter); err != nil {
+				logrus.Errorf("Adding finalizer to '%s/%s' failed: %v",
+					prometheusJmxExporter.Namespace,
+					prometheusJmxExporter.Name,
+					err)
+				return err
+			}
+		}
+
+		status := newPrometheusJmxExporterStatus(prometheusJmxExporter.Status, prometheusJmxExporter.Generation)
+		startupMode := prometheusJmxExporter.Spec.IsStartupMode()
+
+		config, err := loadConfig(prometheusJmxExporter)
 
 		logrus.Debug(config)
 
+		setConfigValidCondition(&status, err)
 		if err != nil {
 			logrus.Errorf("Error during retrieving prometheus jmx exporter config")
+
+			recordEvent(prometheusJmxExporter, nil, v1.EventTypeWarning, eventReasonConfigLoadFailed,
+				"Loading config from %s failed: %v", describeConfigSource(&prometheusJmxExporter.Spec.Config), err)
+
+			keepPodStatuses(&status, prometheusJmxExporter.Status)
+			updateReadyConditions(&status)
+			updatePrometheusJmxExporterStatus(prometheusJmxExporter, status)
 			return err
 		}
 
-		logrus.Infof("Retrieving pods with label: '%s'", prometheusJmxExporter.Spec.LabelSelector)
-		podList, err := queryPods(
-			prometheusJmxExporter.Namespace,
-			labels.SelectorFromSet(prometheusJmxExporter.Spec.LabelSelector).String())
+		// the legacy prometheus annotations are placed on the pods regardless of the monitor
+		if err := syncMonitor(prometheusJmxExporter); err != nil {
+			logrus.Errorf("Syncing monitor of '%s/%s' failed: %v",
+				prometheusJmxExporter.Namespace,
+				prometheusJmxExporter.Name,
+				err)
+
+			recordEvent(prometheusJmxExporter, nil, v1.EventTypeWarning, eventReasonMonitorSyncFailed, "%v", err)
+		}
+
+		logrus.Infof("Retrieving pods with selector: '%s'", metav1.FormatLabelSelector(prometheusJmxExporter.Spec.PodSelector()))
+		podList, err := queryPrometheusJmxExporterPods(prometheusJmxExporter)
 		if err != nil {
 			logrus.Errorf("Error during querying pods : %v", err)
 			return err
@@ -75,30 +110,48 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 
 		logrus.Infof("Pods found: namespace='%s', %s", prometheusJmxExporter.Namespace, formatSimplePods(podList.Items))
 
-		if len(podList.Items) == 0 {
-			return nil
-		}
+		overlapping := overlappingExporterNames(prometheusJmxExporter)
 
-		if err := checkPrometheusJmxExporterConflict(podList, prometheusJmxExporter); err != nil {
-			return err
-		}
+		if len(podList.Items) > 0 {
+			err := checkPrometheusJmxExporterConflict(podList, prometheusJmxExporter)
 
-		processPods(podList.Items, config, prometheusJmxExporter.Spec.Port)
+			setConflictCondition(&status, err, overlapping)
+			if err != nil {
+				recordEvent(prometheusJmxExporter, nil, v1.EventTypeWarning, eventReasonSelectorConflict, "%v", err)
+
+				keepPodStatuses(&status, prometheusJmxExporter.Status)
+				updateReadyConditions(&status)
+				updatePrometheusJmxExporterStatus(prometheusJmxExporter, status)
+				return err
+			}
+
+			if startupMode {
+				// pods are instrumented by the webhook at creation
+				h.syncPodConfigs(podList.Items, config)
+			} else {
+				processPods(podList.Items)
+			}
+		} else {
+			setConflictCondition(&status, nil, overlapping)
+		}
 
 		// update status
-		newStatus := createPrometheusJmxExporterStatus(podList.Items)
+		setPodStatuses(&status, podList.Items, startupMode)
+		updateReadyConditions(&status)
 
-		if !prometheusJmxExporter.Status.Equals(newStatus) {
-			prometheusJmxExporter.Status = createPrometheusJmxExporterStatus(podList.Items)
+		return updatePrometheusJmxExporterStatus(prometheusJmxExporter, status)
 
-			logrus.Infof(
-				"PrometheusJmxExporter: '%s/%s' : Update status",
-				prometheusJmxExporter.Namespace,
-				prometheusJmxExporter.Name)
+	case *v1alpha1.ClusterPrometheusJmxExporter:
+		clusterPrometheusJmxExporter := o
+
+		logrus.Infof("ClusterPrometheusJmxExporter event received for '%s'", clusterPrometheusJmxExporter.Name)
 
-			action.Update(prometheusJmxExporter)
+		if event.Deleted {
+			return nil
 		}
 
+		return h.reconcileClusterPrometheusJmxExporter(clusterPrometheusJmxExporter)
+
 	case *v1.Pod:
 		pod := o
 
@@ -107,7 +160,7 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			return nil
 		}
 
-		prometheusJmxExporters, err := queryPrometheusJmxExporters(pod.Namespace)
+		prometheusJmxExporters, err := queryPodPrometheusJmxExporters(pod)
 		if err != nil {
 			logrus.Errorf("Error during querying prometheusjmxexporters in namespace '%s': %v", pod.Namespace, err)
 			return err
@@ -125,44 +178,51 @@ func (h *Handler) Handle(ctx types.Context, event types.Event) error {
 			logrus.Infof("Pod deleted event received for '%s/%s'", pod.Namespace, pod.Name)
 			// pod is being deleted thus remove the prometheus endpoint that
 			// is exposed by this pod if there is any
-			removePrometheusJmxExporterEndpoint(prometheusJmxExporter, pod)
-			action.Update(prometheusJmxExporter)
-			return nil
+			return storePrometheusJmxExporterStatus(prometheusJmxExporter, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+				removePrometheusJmxExporterEndpoint(latest, pod)
+				removePodStatus(&latest.Status, pod)
+				return true
+			})
 		}
 
-		if isVerified(pod) {
+		// the agent loaded in attach mode is lost when a container restarts
+		restarted := !prometheusJmxExporter.Spec.IsStartupMode() && len(restartedContainers(pod)) > 0
+
+		if isVerified(pod) && !restarted {
 			logrus.Infof("Ignoring pod '%s/%s' as it has already been processed.", pod.Namespace, pod.Name)
+		} else if prometheusJmxExporter.Spec.IsStartupMode() {
+			logrus.Infof("Ignoring pod '%s/%s' as it was not instrumented at creation, restart it to inject the agent.",
+				pod.Namespace, pod.Name)
 		} else {
+			enqueuePod(pod)
+		}
 
-			logrus.Infof("Retrieving prometheus jmx exporter config from configMap '%s/%s:%s'",
-				prometheusJmxExporter.Namespace,
-				prometheusJmxExporter.Spec.Config.ConfigMapName,
-				prometheusJmxExporter.Spec.Config.ConfigMapKey)
+		startupMode := prometheusJmxExporter.Spec.IsStartupMode()
 
-			config, err := getConfig(
-				prometheusJmxExporter.Namespace,
-				prometheusJmxExporter.Spec.Config.ConfigMapName,
-				prometheusJmxExporter.Spec.Config.ConfigMapKey)
+		// the exporter read from the cache may be stale, the state of the pod is applied to the latest one
+		err = storePrometheusJmxExporterStatus(prometheusJmxExporter, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+			endpointsChanged := updatePrometheusJmxExporterEndpoints(latest, pod)
+			podStatusChanged := updatePodStatus(&latest.Status, pod, startupMode)
 
-			logrus.Debug(config)
-			if err != nil {
-				logrus.Errorf("Error during retrieving prometheus jmx exporter config")
-				return err
-			}
+			return endpointsChanged || podStatusChanged
+		})
+		if err != nil {
+			logrus.Errorf("Storing status of '%s/%s' failed: %v",
+				prometheusJmxExporter.Namespace,
+				prometheusJmxExporter.Name,
+				err)
+		}
 
-			if err := processPod(pod, config, prometheusJmxExporter.Spec.Port); err != nil {
-				return err
-			}
+		return err
 
-			if updatePrometheusJmxExporterEndpoints(prometheusJmxExporter, pod) {
-				logrus.Infof(
-					"PrometheusJmxExporter: '%s/%s' : Update status",
-					prometheusJmxExporter.Namespace,
-					prometheusJmxExporter.Name)
+	case *v1.ConfigMap:
+		configMap := o
 
-				action.Update(prometheusJmxExporter)
-			}
+		if event.Deleted {
+			return nil
 		}
+
+		return h.reloadPrometheusJmxExporterConfigs(configMap)
 	}
 	return nil
 }
@@ -176,14 +236,18 @@ func queryPrometheusJmxExporters(namespace string) (*v1alpha1.PrometheusJmxExpor
 		},
 	}
 
-	listOptions := query.WithListOptions(&metav1.ListOptions{
-		IncludeUninitialized: false,
-	})
-
-	if err := query.List(namespace, &jmxExporterList, listOptions); err != nil {
+	prometheusJmxExporters, err := cachedPrometheusJmxExporters(namespace, cache.NamespaceIndex, namespace)
+	if err != nil {
 		logrus.Errorf("Failed to query prometheusjmxexporters : %v", err)
 		return nil, err
 	}
+	jmxExporterList.Items = prometheusJmxExporters
+
+	derivedJmxExporters, err := queryDerivedPrometheusJmxExporters(namespace)
+	if err != nil {
+		return nil, err
+	}
+	jmxExporterList.Items = append(jmxExporterList.Items, derivedJmxExporters...)
 
 	return &jmxExporterList, nil
 }
@@ -201,25 +265,26 @@ func getConfig(namespace, configMapName, configMapKey string) (*v1alpha1.Prometh
 		},
 	}
 
-	getOptions := query.WithGetOptions(&metav1.GetOptions{
-		IncludeUninitialized: false,
-	})
-
-	err := query.Get(&configMap, getOptions)
+	err := getObject(&configMap)
 	if err != nil {
 		logrus.Errorf("Failed to get configMap namespace='%s', name='%s': %v", namespace, configMapName, err)
 		return nil, err
 	}
 
+	return parseConfig(&configMap, configMapKey)
+}
+
+// parseConfig parses the config data stored under configMapKey of configMap
+func parseConfig(configMap *v1.ConfigMap, configMapKey string) (*v1alpha1.PrometheusJmxExporterConfig, error) {
 	config, ok := configMap.Data[configMapKey]
 	if !ok {
-		return nil, fmt.Errorf("configMap data with key '%s' not found in configMap '%s/%s'", configMapKey, namespace, configMapName)
+		return nil, fmt.Errorf("configMap data with key '%s' not found in configMap '%s/%s'", configMapKey, configMap.Namespace, configMap.Name)
 	}
 
 	logrus.Debugf("Validating config data '%s'", config)
 
 	var configObj = v1alpha1.PrometheusJmxExporterConfig{}
-	err = yaml.Unmarshal([]byte(config), &configObj)
+	err := yaml.Unmarshal([]byte(config), &configObj)
 	if err != nil {
 		return nil, err
 	}
@@ -227,57 +292,49 @@ func getConfig(namespace, configMapName, configMapKey string) (*v1alpha1.Prometh
 	return &configObj, err
 }
 
-// queryPods returns list of pods according to the labelSelector
-func queryPods(namespace, labelSelector string) (*v1.PodList, error) {
+// queryPods returns the running pods of namespace matched by selector
+func queryPods(namespace string, selector *metav1.LabelSelector) (*v1.PodList, error) {
 	podList := v1.PodList{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Pod",
 			APIVersion: "v1",
 		},
 	}
-	listOptions := query.WithListOptions(&metav1.ListOptions{
-		LabelSelector:        labelSelector,
-		IncludeUninitialized: false,
-	})
 
-	err := query.List(namespace, &podList, listOptions)
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
 	if err != nil {
-		logrus.Errorf("Failed to query pods : %v", err)
 		return nil, err
 	}
 
-	var filteredPods []v1.Pod
+	// pods selected by matchLabels are looked up by one of the labels, otherwise all pods of the namespace are checked
+	index, indexKey := cache.NamespaceIndex, namespace
+	if len(selector.MatchLabels) > 0 {
+		index, indexKey = labelIndex, selectorIndexKey(namespace, selector)
+	}
 
-	for i := 0; i < len(podList.Items); i++ {
-		if podList.Items[i].Status.Phase == v1.PodRunning {
-			filteredPods = append(filteredPods, podList.Items[i])
-		}
+	pods, err := cachedPods(namespace, index, indexKey)
+	if err != nil {
+		logrus.Errorf("Failed to query pods : %v", err)
+		return nil, err
 	}
 
-	podList.Items = filteredPods
+	for i := 0; i < len(pods); i++ {
+		if pods[i].Status.Phase == v1.PodRunning && labelSelector.Matches(labels.Set(pods[i].Labels)) {
+			podList.Items = append(podList.Items, pods[i])
+		}
+	}
 
 	return &podList, nil
-
 }
 
-// processPods loads prometheus jmx exporter agent into each pod
-func processPods(pods []v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig, portNumber int) {
-	logrus.Info("Processing running pods...")
+// processPods queues each pod to load the prometheus jmx exporter agent into or to reload the config of
+// the already instrumented ones. The pods are processed by the pod workers concurrently.
+func processPods(pods []v1.Pod) {
+	logrus.Infof("Queueing running pods %s", formatSimplePods(pods))
 
 	for i := 0; i < len(pods); i++ {
-		pod := &pods[i]
-
-		if isVerified(pod) {
-			logrus.Infof("Ignoring pod '%s/%s' as it has already been processed.", pod.Namespace, pod.Name)
-		} else {
-			err := processPod(pod, config, portNumber)
-			if err != nil {
-				logrus.Warnf("Processing pod failed: %v", err)
-			}
-		}
+		enqueuePod(&pods[i])
 	}
-
-	logrus.Info("Processing pods finished.")
 }
 
 // isVerified returns true of the pod was already processed and verified
@@ -287,57 +344,93 @@ func isVerified(pod *v1.Pod) bool {
 	return ok && (v == prometheusJmxExporterAnnotationVerified || v == prometheusJmxExporterAnnotationVerifiedFailed)
 }
 
-// processPod loads prometheus jmx exporter agent into the pod
-func processPod(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig, portNumber int) error {
+// processPod loads prometheus jmx exporter agent into the java processes running in the containers
+// of the pod selected by prometheusJmxExporter. The pod is marked as verified on success, the caller is
+// expected to mark it as verify failed on error.
+func (h *Handler) processPod(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig, prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) error {
 	logrus.Infof("Inspecting pod '%s'", pod.Name)
 
-	if len(pod.Spec.Containers) > 0 {
-		// TODO: prometheus doesn't support scraping multiple containers running on the same pod
-		// TODO: in case of multiple containers which container we should go with
-		container := pod.Spec.Containers[0]
+	spec := &prometheusJmxExporter.Spec
 
-		pids, err := queryJavaProcesses(pod, &container)
+	containers, err := selectContainers(pod, spec.ContainerSelector)
+	if err != nil {
+		recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonContainerNotFound, "%v", err)
+		return err
+	}
 
-		if err != nil {
-			logrus.Infof("Mark pod '%s' as verify failed", pod.Name)
+	allJavaContainers := spec.ContainerSelector != nil && spec.ContainerSelector.AllJavaContainers
 
-			podVerifiedFailed(pod)
-			return err
-		}
+	// the agents loaded at a previous attempt are still running, these are not loaded again
+	endpoints := attachedEndpoints(pod)
+	reservedPorts := make(map[int]bool)
+	for _, endpoint := range endpoints {
+		reservedPorts[endpoint.Port] = true
+	}
 
-		if len(pids) > 1 {
-			// TODO: should we support multiple java processes per container?
-			return fmt.Errorf("multiple java processes found")
-		}
+	for i := 0; i < len(containers); i++ {
+		container := containers[i]
 
-		// copy jars
-		if err := copyJmxPrometheusExporterJars(pod, &container); err != nil {
-			return err
+		procs, err := queryJavaProcesses(h.executor, pod, &container)
+		if err == nil {
+			procs, err = selectJavaProcesses(procs, spec.ProcessSelector)
+		}
+		if err == nil && len(procs) > 0 {
+			procs = unattachedJavaProcesses(procs, endpoints, container.Name)
+			if len(procs) == 0 {
+				logrus.Infof("Skipping container '%s/%s/%s' as the agent has already been loaded into its java processes",
+					pod.Namespace, pod.Name, container.Name)
+				continue
+			}
 		}
+		if err == nil && len(procs) == 0 {
+			err = fmt.Errorf("no java process found in container '%s'", container.Name)
+		}
+
+		if err != nil {
+			if allJavaContainers {
+				logrus.Infof("Skipping container '%s/%s/%s': %v", pod.Namespace, pod.Name, container.Name, err)
+				continue
+			}
 
-		// copy config to pod container
-		if err := copyPrometheusJmxExporterConfToPod(config, pod, &container); err != nil {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJavaProcessNotFound,
+				"Java process not found in container '%s': %v", container.Name, err)
 			return err
 		}
 
-		// open port for prometheus jmx exporter
+		if len(procs) > 1 && spec.ProcessSelector == nil {
+			err := fmt.Errorf("multiple java processes found in container '%s', use processSelector to select them", container.Name)
 
-		// TODO: port number should be determined dynamically such way that doesn't conflicts with ports
-		// already in use by the processes running in the container
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonMultipleJavaProcesses, "%v", err)
+			return err
+		}
 
-		logrus.Infof("Exposing port number %d on '%s/%s/%s'", portNumber, pod.Namespace, pod.Name, container.Name)
+		containerEndpoints, err := h.processContainer(pod, &container, config, prometheusJmxExporter, procs, reservedPorts)
 
-		if err := exposeContainerPort(int32(portNumber), pod, &container); err != nil {
+		// the agents loaded are recorded even if loading the others failed thus a retry doesn't load them again
+		if len(containerEndpoints) > 0 {
+			endpoints = append(endpoints, containerEndpoints...)
+			if annotateErr := annotateForPrometheus(pod, endpoints); annotateErr != nil {
+				return annotateErr
+			}
+		}
+		if err != nil {
 			return err
 		}
+	}
 
-		// load prometheus jmx exporter agent
-		if err := loadPrometheusJmxExporterAgent(pod, &container, portNumber, pids[0]); err != nil {
-			return err
+	if len(containers) > 0 {
+		if len(endpoints) == 0 {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJavaProcessNotFound,
+				"Java process not found in any of the containers")
+			return fmt.Errorf("no java process found in pod '%s/%s'", pod.Namespace, pod.Name)
 		}
 
-		// annotade pod for prometheus
-		annotateForPrometheus(pod, portNumber)
+		recordEvent(prometheusJmxExporter, pod, v1.EventTypeNormal, eventReasonJmxExporterAttached,
+			"Prometheus jmx exporter attached: %s", formatEndpointsAnnotation(endpoints))
+
+		if err := annotateConfigHash(pod, config); err != nil {
+			return err
+		}
 	}
 
 	logrus.Infof("Mark pod '%s' as verified", pod.Name)
@@ -346,18 +439,89 @@ func processPod(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig, portN
 
 }
 
-// podVerified updates the pod annotations to mark it as verified.
-func podVerifiedFailed(pod *v1.Pod) error {
-	annotations := map[string]string{
-		prometheusJmxExporterAnnotationKey: prometheusJmxExporterAnnotationVerifiedFailed,
+// processContainer loads prometheus jmx exporter agent into the java processes procs running inside container.
+// Each agent listens on a distinct port that is not in reservedPorts, the ports taken are added to reservedPorts.
+// Returns the endpoints published by the agents, which are the agents loaded before the failure in case of an error.
+func (h *Handler) processContainer(pod *v1.Pod, container *v1.Container, config *v1alpha1.PrometheusJmxExporterConfig,
+	prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, procs []javaProcess, reservedPorts map[int]bool) ([]*v1alpha1.MetricsEndpoint, error) {
+	// the tools of the container are probed once for both copies
+	transfer, err := newContainerTransfer(h.executor, pod.Namespace, pod.Name, container.Name)
+	if err != nil {
+		recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJmxExporterAttachFailed,
+			"Copying files to container '%s' failed: %v", container.Name, err)
+		return nil, err
 	}
-	return annotatePod(pod, annotations)
+
+	recordEvent(prometheusJmxExporter, pod, v1.EventTypeNormal, eventReasonFileTransferSelected,
+		"Copying files to container '%s' using %s", container.Name, transfer)
+
+	// copy jars
+	if err := copyJmxPrometheusExporterJars(h.executor, transfer, pod, container); err != nil {
+		recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJmxExporterAttachFailed,
+			"Copying jars to container '%s' failed: %v", container.Name, err)
+		return nil, err
+	}
+
+	// copy config to pod container
+	if err := copyPrometheusJmxExporterConfToPod(h.executor, transfer, config, pod, container); err != nil {
+		recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJmxExporterAttachFailed,
+			"Copying config to container '%s' failed: %v", container.Name, err)
+		return nil, err
+	}
+
+	var endpoints []*v1alpha1.MetricsEndpoint
+
+	for _, proc := range procs {
+		// open port for prometheus jmx exporter
+		portNumber, err := selectPort(h.executor, pod, container, &prometheusJmxExporter.Spec, reservedPorts)
+		if err != nil {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonPortConflict,
+				"Selecting port in container '%s' failed: %v", container.Name, err)
+			return endpoints, err
+		}
+
+		logrus.Infof("Exposing port number %d on '%s/%s/%s'", portNumber, pod.Namespace, pod.Name, container.Name)
+
+		if err := exposeContainerPort(int32(portNumber), pod, container); err != nil {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonPortConflict,
+				"Exposing port %d in container '%s' failed: %v", portNumber, container.Name, err)
+			return endpoints, err
+		}
+
+		// load prometheus jmx exporter agent
+		if err := loadPrometheusJmxExporterAgent(h.executor, pod, container, portNumber, proc.Pid); err != nil {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonJmxExporterAttachFailed,
+				"Loading agent into process %s of container '%s' failed: %v", proc.Pid, container.Name, err)
+			return endpoints, err
+		}
+
+		reservedPorts[portNumber] = true
+		endpoints = append(endpoints, &v1alpha1.MetricsEndpoint{
+			Pod:       pod.Name,
+			Container: container.Name,
+			Pid:       proc.Pid,
+			Port:      portNumber,
+		})
+	}
+
+	return endpoints, nil
 }
 
 // podVerified updates the pod annotations to mark it as verified.
 func podVerified(pod *v1.Pod) error {
+	delete(pod.Annotations, prometheusJmxExporterLastErrorAnnotationKey)
+	delete(pod.Annotations, prometheusJmxExporterAttemptsAnnotationKey)
+	delete(pod.Annotations, prometheusJmxExporterNextRetryAnnotationKey)
+	delete(pod.Annotations, prometheusJmxExporterHealthAnnotationKey)
+
 	annotations := map[string]string{
-		prometheusJmxExporterAnnotationKey: prometheusJmxExporterAnnotationVerified,
+		prometheusJmxExporterAnnotationKey:           prometheusJmxExporterAnnotationVerified,
+		prometheusJmxExporterAttachTimeAnnotationKey: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// the containers are tracked to reload the agent when they are restarted
+	if containerStates := formatContainerStates(pod); len(containerStates) > 0 {
+		annotations[prometheusJmxExporterContainerStatesAnnotationKey] = containerStates
 	}
 
 	return annotatePod(pod, annotations)
@@ -373,7 +537,7 @@ func annotatePod(pod *v1.Pod, annotations map[string]string) error {
 		pod.Annotations[key] = value
 	}
 
-	err := action.Update(pod)
+	err := updateObject(pod)
 	if err != nil {
 		logrus.Errorf("Updating pod '%s' failed: %v", pod.Name, err)
 		return err
@@ -382,40 +546,8 @@ func annotatePod(pod *v1.Pod, annotations map[string]string) error {
 	return nil
 }
 
-// queryJavaProcesses inspects container for running java processes
-func queryJavaProcesses(pod *v1.Pod, container *v1.Container) ([]string, error) {
-	logrus.Infof("Inspecting container '%s' for java processes", container.Name)
-
-	stdout, err := execCommand(pod.Namespace, pod.Name, nil, container,
-		"sh", "-c", "$JAVA_HOME/bin/jps")
-
-	if err != nil {
-		logrus.Warnf("Failed to retrieve java process list: %v", err)
-
-		return nil, err
-	} else {
-		var javaProcIds []string
-
-		procs := strings.Split(strings.TrimSpace(stdout), "\n")
-		for _, proc := range procs {
-			idAndClassName := strings.Split(proc, " ")
-
-			procId := idAndClassName[0]
-			className := idAndClassName[1]
-
-			if !strings.EqualFold(className, "jps") { // skip jps
-				javaProcIds = append(javaProcIds, procId)
-			}
-		}
-
-		logrus.Infof("Java processes: %s", javaProcIds)
-
-		return javaProcIds, nil
-	}
-}
-
-// copyPrometheusJmxExporterConfToPod creates  config file from the configContent and copies it to the pod container
-func copyPrometheusJmxExporterConfToPod(configContent *v1alpha1.PrometheusJmxExporterConfig, pod *v1.Pod, container *v1.Container) error {
+// copyPrometheusJmxExporterConfToPod creates  config file from the configContent and copies it to the pod container with transfer
+func copyPrometheusJmxExporterConfToPod(executor PodExecutor, transfer *containerTransfer, configContent *v1alpha1.PrometheusJmxExporterConfig, pod *v1.Pod, container *v1.Container) error {
 	tmpDir, err := ioutil.TempDir("", "prometheus-jmx-exporter-conf")
 	if err != nil {
 		return err
@@ -440,7 +572,7 @@ func copyPrometheusJmxExporterConfToPod(configContent *v1alpha1.PrometheusJmxExp
 		return err
 	}
 
-	err = copyToPod(pod.Namespace, pod.Name, container, tmpDir, path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir))
+	err = copyToPod(executor, pod.Namespace, pod.Name, container, transfer, tmpDir, path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir))
 	if err != nil {
 		logrus.Errorf("Copying config for jmx-exporter to container '%s/%s/%s'failed: %v",
 			pod.Namespace, pod.Name, container.Name, err)
@@ -450,9 +582,9 @@ func copyPrometheusJmxExporterConfToPod(configContent *v1alpha1.PrometheusJmxExp
 	return nil
 }
 
-// copyJmxPrometheusExporterJars copies the jars of prometheus jmx exporter to pod
-func copyJmxPrometheusExporterJars(pod *v1.Pod, container *v1.Container) error {
-	err := copyToPod(pod.Namespace, pod.Name, container, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir)
+// copyJmxPrometheusExporterJars copies the jars of prometheus jmx exporter to pod with transfer
+func copyJmxPrometheusExporterJars(executor PodExecutor, transfer *containerTransfer, pod *v1.Pod, container *v1.Container) error {
+	err := copyToPod(executor, pod.Namespace, pod.Name, container, transfer, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir)
 	if err != nil {
 		logrus.Errorf("Copying jmx-exporter-loader jars to container '%s/%s/%s'failed: %v",
 			pod.Namespace, pod.Name, container.Name, err)
@@ -462,29 +594,80 @@ func copyJmxPrometheusExporterJars(pod *v1.Pod, container *v1.Container) error {
 	return nil
 }
 
-// exposeContainerPort exposes portNumber on container
+// exposeContainerPort exposes portNumber on container. The port exposed at a previous attempt is kept as is.
 func exposeContainerPort(portNumber int32, pod *v1.Pod, container *v1.Container) error {
 	for _, port := range container.Ports {
-		if port.ContainerPort == portNumber {
-			return fmt.Errorf("port number %d is already in use", portNumber)
+		if port.ContainerPort != portNumber {
+			continue
+		}
+		if port.Name == prometheusJmxExporterContainerPortName {
+			return nil
 		}
+
+		return fmt.Errorf("port number %d is already in use", portNumber)
 	}
 
 	container.Ports = append(container.Ports, v1.ContainerPort{
 		ContainerPort: portNumber,
-		Name:          "prometheusJmxMetrics",
+		Name:          prometheusJmxExporterContainerPortName,
 		Protocol:      v1.ProtocolTCP,
 	})
 
-	return action.Update(pod)
+	return updateObject(pod)
+}
+
+// javaLoaderUnavailableMessages are the messages of the errors reported when the java loader can't run in the
+// container: the shell or $JAVA_HOME/bin/java is missing, or the JVM lacks the attach API
+var javaLoaderUnavailableMessages = []string{
+	"not found",
+	"No such file or directory",
+	"exit code 126",
+	"exit code 127",
+	"com/sun/tools/attach",
+	"com.sun.tools.attach",
 }
 
 // loadPrometheusJmxExporterAgent loads prometheus jmx exporter agent into the process with pid
-// running inside container
-func loadPrometheusJmxExporterAgent(pod *v1.Pod, container *v1.Container, portNumber int, pid string) error {
+// running inside container. The agent is loaded by the java loader which needs the attach API of
+// a JDK in the container. The agent is loaded by the native attacher only if the java loader can't
+// run in the container, other errors of the java loader are returned.
+func loadPrometheusJmxExporterAgent(executor PodExecutor, pod *v1.Pod, container *v1.Container, portNumber int, pid string) error {
 	logrus.Infof("Loading prometheus jmx exporter agent into process with pid %s running inside '%s/%s/%s'",
 		pid, pod.Namespace, pod.Name, container.Name)
 
+	err := loadAgentWithJavaLoader(executor, pod, container, portNumber, pid)
+	if err == nil {
+		return nil
+	}
+
+	if !javaLoaderUnavailable(err) {
+		return fmt.Errorf("loading agent with java loader failed: %v", err)
+	}
+
+	logrus.Infof("Java loader can't run in container '%s', using native attacher: %v", container.Name, err)
+
+	if nativeErr := loadAgentWithNativeAttacher(executor, pod, container, portNumber, pid); nativeErr != nil {
+		return fmt.Errorf("java loader: %v, native attacher: %v", err, nativeErr)
+	}
+
+	return nil
+}
+
+// javaLoaderUnavailable returns true if err reports that the java loader can't run in the container
+func javaLoaderUnavailable(err error) bool {
+	for _, message := range javaLoaderUnavailableMessages {
+		if strings.Contains(err.Error(), message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadAgentWithJavaLoader loads prometheus jmx exporter agent into the process with pid by running
+// the loader jar with $JAVA_HOME/bin/java inside container
+func loadAgentWithJavaLoader(executor PodExecutor, pod *v1.Pod, container *v1.Container, portNumber int, pid string) error {
+
 	var javaCmd bytes.Buffer
 	javaCmd.WriteString("$JAVA_HOME/bin/java -cp ")
 	javaCmd.WriteString(path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterLoaderJar))
@@ -501,94 +684,219 @@ func loadPrometheusJmxExporterAgent(pod *v1.Pod, container *v1.Container, portNu
 
 	command := []string{"sh", "-c", javaCmd.String()}
 
-	_, err := execCommand(pod.Namespace, pod.Name, nil, container, command...)
+	_, err := execCommand(executor, pod.Namespace, pod.Name, nil, container, command...)
 	return err
 }
 
-// annotateForPrometheus places annotation on the pod that provide
-func annotateForPrometheus(pod *v1.Pod, portNumber int) error {
-	annotations := map[string]string{
-		"prometheus.io/scrape": "true",
-		"prometheus.io/port":   strconv.Itoa(portNumber),
+// loadAgentWithNativeAttacher loads prometheus jmx exporter agent into the process with pid through the
+// attach mechanism of HotSpot JVMs using the attacher binary copied into container. It needs neither a JDK
+// nor a shell in the container.
+func loadAgentWithNativeAttacher(executor PodExecutor, pod *v1.Pod, container *v1.Container, portNumber int, pid string) error {
+	agentOptions := strconv.Itoa(portNumber) + ":" +
+		path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir, prometheusJmxExportedConfigFilename)
+
+	command := []string{
+		path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAttacherBinary),
+		pid,
+		path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAgentJar),
+		agentOptions,
+	}
+
+	_, err := execCommand(executor, pod.Namespace, pod.Name, nil, container, command...)
+	return err
+}
+
+// annotateForPrometheus places annotation on the pod that provide the endpoints for prometheus.
+// As prometheus.io/port can hold only one port it's set to the port of the first endpoint, all
+// endpoints are listed in the endpoints annotation. The states of the containers are recorded
+// along with the endpoints to tell whether the agents are still running.
+func annotateForPrometheus(pod *v1.Pod, endpoints []*v1alpha1.MetricsEndpoint) error {
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+
+	pod.Annotations[prometheusScrapeAnnotationKey] = "true"
+	pod.Annotations[prometheusPortAnnotationKey] = strconv.Itoa(endpoints[0].Port)
+	pod.Annotations[prometheusJmxExporterEndpointsAnnotationKey] = formatEndpointsAnnotation(endpoints)
+
+	annotations := make(map[string]string)
+	if containerStates := formatContainerStates(pod); len(containerStates) > 0 {
+		annotations[prometheusJmxExporterContainerStatesAnnotationKey] = containerStates
 	}
 
 	return annotatePod(pod, annotations)
 }
 
-// createPrometheusJmxExporterStatus collects the endpoints through which prometheus can
-// scrape metrics published by the prometheus jmx exporter
-func createPrometheusJmxExporterStatus(pods []v1.Pod) v1alpha1.PrometheusJmxExporterStatus {
-	var status v1alpha1.PrometheusJmxExporterStatus
+// unattachedJavaProcesses returns the java processes procs of container into which the agent hasn't been loaded
+// according to endpoints
+func unattachedJavaProcesses(procs []javaProcess, endpoints []*v1alpha1.MetricsEndpoint, container string) []javaProcess {
+	var unattached []javaProcess
+
+	for _, proc := range procs {
+		attached := false
+		for _, endpoint := range endpoints {
+			if endpoint.Container == container && endpoint.Pid == proc.Pid {
+				attached = true
+				break
+			}
+		}
+
+		if !attached {
+			unattached = append(unattached, proc)
+		}
+	}
+
+	return unattached
+}
+
+// setPodStatuses collects the endpoints through which prometheus can
+// scrape metrics published by the prometheus jmx exporter and the injection state of pods into status
+func setPodStatuses(status *v1alpha1.PrometheusJmxExporterStatus, pods []v1.Pod, startupMode bool) {
+	status.MetricsEndpoints = nil
+	status.Pods = nil
 
 	for i := 0; i < len(pods); i++ {
 		pod := pods[i]
 
-		if endpoint := createMetricEndpoint(&pod); endpoint != nil {
-			status.MetricsEndpoints = append(status.MetricsEndpoints, endpoint)
-		}
+		status.MetricsEndpoints = append(status.MetricsEndpoints, createMetricEndpoints(&pod)...)
+		status.Pods = append(status.Pods, createPodStatus(&pod, startupMode))
 	}
+}
 
-	return status
+// keepPodStatuses copies the endpoints and the injection state of pods from the previous status
+// when the pods couldn't be processed
+func keepPodStatuses(status *v1alpha1.PrometheusJmxExporterStatus, previous v1alpha1.PrometheusJmxExporterStatus) {
+	status.MetricsEndpoints = previous.MetricsEndpoints
+	status.Pods = previous.Pods
 }
 
-// updatePrometheusJmxExporterEndpoints adds prometheus endpoint published by pod
+// updatePrometheusJmxExporterEndpoints adds prometheus endpoints published by pod
 // to the metrics endpoints of prometheusJmxExporter. If the pod doesn't exposes port for prometheus
-// or port hasn't changed then metrics endpoints of prometheusJmxExporter is not changed.
+// or ports haven't changed then metrics endpoints of prometheusJmxExporter is not changed.
 // Returns true if metrics endpoints of prometheusJmxExporter is changed otherwise returns false.
 func updatePrometheusJmxExporterEndpoints(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, pod *v1.Pod) bool {
-	if endpoint := createMetricEndpoint(pod); endpoint != nil {
+	changed := false
+
+	for _, endpoint := range createMetricEndpoints(pod) {
+		found := false
+
 		for _, endpointUpd := range prometheusJmxExporter.Status.MetricsEndpoints {
-			if endpointUpd.Pod == pod.Name {
+			if endpointUpd.Namespace == endpoint.Namespace && endpointUpd.Pod == endpoint.Pod &&
+				endpointUpd.Container == endpoint.Container && endpointUpd.Pid == endpoint.Pid {
+				found = true
 
-				if endpointUpd.Port != endpoint.Port {
+				if endpointUpd.Port != endpoint.Port || endpointUpd.Health != endpoint.Health ||
+					endpointUpd.HealthMessage != endpoint.HealthMessage {
 					endpointUpd.Port = endpoint.Port
-
-					return true
+					endpointUpd.Health = endpoint.Health
+					endpointUpd.HealthMessage = endpoint.HealthMessage
+					changed = true
 				}
-
-				return false
+				break
 			}
 		}
 
-		prometheusJmxExporter.Status.MetricsEndpoints = append(prometheusJmxExporter.Status.MetricsEndpoints, endpoint)
-		return true
+		if !found {
+			prometheusJmxExporter.Status.MetricsEndpoints = append(prometheusJmxExporter.Status.MetricsEndpoints, endpoint)
+			changed = true
+		}
 	}
 
-	return false
+	return changed
 }
 
-// removePrometheusJmxExporterEndpoint removes the endpoint entry from prometheusJmxExporter.Status.MetricsEndpoints
+// removePrometheusJmxExporterEndpoint removes the endpoint entries from prometheusJmxExporter.Status.MetricsEndpoints
 // that corresponds to pod
 func removePrometheusJmxExporterEndpoint(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, pod *v1.Pod) {
-	matchIdx := -1
-	for i, endpointUpd := range prometheusJmxExporter.Status.MetricsEndpoints {
-		if endpointUpd.Pod == pod.Name {
-			matchIdx = i
-			break
+	var endpoints []*v1alpha1.MetricsEndpoint
+	for _, endpoint := range prometheusJmxExporter.Status.MetricsEndpoints {
+		if endpoint.Pod != pod.Name || !isInNamespace(endpoint.Namespace, pod) {
+			endpoints = append(endpoints, endpoint)
 		}
 	}
-	if matchIdx == 0 {
-		prometheusJmxExporter.Status.MetricsEndpoints = prometheusJmxExporter.Status.MetricsEndpoints[1:]
-	} else if matchIdx > 0 {
-		prometheusJmxExporter.Status.MetricsEndpoints =
-			append(prometheusJmxExporter.Status.MetricsEndpoints[:matchIdx], prometheusJmxExporter.Status.MetricsEndpoints[matchIdx+1:]...)
-	}
+
+	prometheusJmxExporter.Status.MetricsEndpoints = endpoints
 }
 
-func createMetricEndpoint(pod *v1.Pod) *v1alpha1.MetricsEndpoint {
-	if enabled, ok := pod.Annotations["prometheus.io/scrape"]; ok && enabled == "true" {
-		if portStr, ok := pod.Annotations["prometheus.io/port"]; ok {
+// createMetricEndpoints returns the endpoints published by pod. Pods processed by earlier
+// versions of the operator have no endpoints annotation, for these the endpoint is created from
+// the prometheus.io/port annotation.
+func createMetricEndpoints(pod *v1.Pod) []*v1alpha1.MetricsEndpoint {
+	if enabled, ok := pod.Annotations[prometheusScrapeAnnotationKey]; ok && enabled == "true" {
+		if endpoints, ok := pod.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; ok {
+			metricsEndpoints := parseEndpointsAnnotation(pod.Name, endpoints)
+			for _, endpoint := range metricsEndpoints {
+				endpoint.Namespace = pod.Namespace
+			}
+			setEndpointsHealth(pod, metricsEndpoints)
+
+			return metricsEndpoints
+		}
+
+		if portStr, ok := pod.Annotations[prometheusPortAnnotationKey]; ok {
 			port, _ := strconv.Atoi(portStr)
 
-			return &v1alpha1.MetricsEndpoint{
-				Pod:  pod.Name,
-				Port: port,
+			metricsEndpoints := []*v1alpha1.MetricsEndpoint{
+				{
+					Namespace: pod.Namespace,
+					Pod:       pod.Name,
+					Port:      port,
+				},
 			}
+			setEndpointsHealth(pod, metricsEndpoints)
+
+			return metricsEndpoints
 		}
 	}
 	return nil
 }
 
+// formatEndpointsAnnotation returns the value of the endpoints annotation in
+// <container>[/<pid>]:<port>[,<container>[/<pid>]:<port>...] format
+func formatEndpointsAnnotation(endpoints []*v1alpha1.MetricsEndpoint) string {
+	var items []string
+	for _, endpoint := range endpoints {
+		if len(endpoint.Pid) > 0 {
+			items = append(items, fmt.Sprintf("%s/%s:%d", endpoint.Container, endpoint.Pid, endpoint.Port))
+		} else {
+			items = append(items, fmt.Sprintf("%s:%d", endpoint.Container, endpoint.Port))
+		}
+	}
+
+	return strings.Join(items, ",")
+}
+
+// parseEndpointsAnnotation parses the value of the endpoints annotation of pod
+func parseEndpointsAnnotation(podName, value string) []*v1alpha1.MetricsEndpoint {
+	var endpoints []*v1alpha1.MetricsEndpoint
+
+	for _, item := range strings.Split(value, ",") {
+		idx := strings.LastIndex(item, ":")
+		if idx < 0 {
+			continue
+		}
+
+		port, err := strconv.Atoi(item[idx+1:])
+		if err != nil {
+			continue
+		}
+
+		container, pid := item[:idx], ""
+		if pidIdx := strings.Index(container, "/"); pidIdx >= 0 {
+			container, pid = container[:pidIdx], container[pidIdx+1:]
+		}
+
+		endpoints = append(endpoints, &v1alpha1.MetricsEndpoint{
+			Pod:       podName,
+			Container: container,
+			Pid:       pid,
+			Port:      port,
+		})
+	}
+
+	return endpoints
+}
+
 // findExporterForPod searches through prometheusJmxExporterList and returns PrometheusJmxExporter
 // of which  pod label selector matches the labels of pod. In case of multiple matches found return with error.
 func findExporterForPod(prometheusJmxExporterList *v1alpha1.PrometheusJmxExporterList, pod *v1.Pod) (*v1alpha1.PrometheusJmxExporter, error) {
@@ -597,8 +905,12 @@ func findExporterForPod(prometheusJmxExporterList *v1alpha1.PrometheusJmxExporte
 	for i := 0; i < len(prometheusJmxExporterList.Items); i++ {
 		prometheusJmxExporter := prometheusJmxExporterList.Items[i]
 
-		selector := labels.SelectorFromSet(prometheusJmxExporter.Spec.LabelSelector)
-		if selector.Matches(labels.Set(pod.Labels)) {
+		if prometheusJmxExporter.DeletionTimestamp != nil {
+			// pods of prometheusJmxExporters being deleted are cleaned up not processed
+			continue
+		}
+
+		if selectsPod(&prometheusJmxExporter, pod) {
 			matchIdx = append(matchIdx, i)
 		}
 	}
@@ -635,10 +947,8 @@ func checkPrometheusJmxExporterConflict(podList *v1.PodList, prometheusJmxExport
 		for j := 0; j < len(prometheusJmxExporters.Items); j++ {
 			otherPrometheusJmxExporter := prometheusJmxExporters.Items[j]
 
-			if otherPrometheusJmxExporter.Name != prometheusJmxExporter.Name {
-				selector := labels.SelectorFromSet(otherPrometheusJmxExporter.Spec.LabelSelector)
-
-				if selector.Matches(labels.Set(pod.Labels)) {
+			if !isSameExporter(&otherPrometheusJmxExporter, prometheusJmxExporter) {
+				if selectsPod(&otherPrometheusJmxExporter, &pod) {
 
 					logrus.Errorf("prometheusjmxexporter '%s' for pod '%s/%s' already defined",
 						pod.Namespace,
@@ -0,0 +1,275 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestPod returns a running pod with containers stored in api
+func newTestPod(api *fakeAPI, name string, containers ...string) *v1.Pod {
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "test"},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			PodIP: "10.0.0.1",
+		},
+	}
+
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: container, Image: "openjdk:8"})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+			Name:        container,
+			ContainerID: "docker://" + container,
+			Ready:       true,
+		})
+	}
+
+	api.store("pods", pod)
+
+	return pod
+}
+
+// newTestPrometheusJmxExporter returns a PrometheusJmxExporter selecting the test pods
+func newTestPrometheusJmxExporter() *v1alpha1.PrometheusJmxExporter {
+	return &v1alpha1.PrometheusJmxExporter{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "PrometheusJmxExporter",
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.PrometheusJmxExporterSpec{
+			LabelSelector: map[string]string{"app": "test"},
+			Port:          9020,
+		},
+	}
+}
+
+var testConfig = &v1alpha1.PrometheusJmxExporterConfig{Rules: []v1alpha1.PrometheusJmxExporterConfigRules{}}
+
+func TestProcessPodLoadsAgent(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	key := fakeContainerKey("default", "app-1", "app")
+	executor.JpsOutput[key] = "42 com.example.App -Xmx256m\n"
+
+	if err := handler.processPod(pod, testConfig, newTestPrometheusJmxExporter()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if agents := executor.LoadedAgents[key]; !reflect.DeepEqual(agents, []string{"42:9020"}) {
+		t.Errorf("expected agent loaded into process 42 on port 9020, got %v", agents)
+	}
+
+	for _, file := range []string{prometheusJmxExporterLoaderJar, prometheusJmxExporterAgentJar, path.Join(prometheusJmxExporterTargetConfDir, prometheusJmxExportedConfigFilename)} {
+		if _, ok := executor.Files[key][path.Join(prometheusJmxExporterTargetDir, file)]; !ok {
+			t.Errorf("expected %s copied, got %v", file, executor.Files[key])
+		}
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	expectedAnnotations := map[string]string{
+		prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerified,
+		prometheusScrapeAnnotationKey:               "true",
+		prometheusPortAnnotationKey:                 "9020",
+		prometheusJmxExporterEndpointsAnnotationKey: "app/42:9020",
+	}
+	for annotation, value := range expectedAnnotations {
+		if stored.Annotations[annotation] != value {
+			t.Errorf("expected annotation %s=%s, got '%s'", annotation, value, stored.Annotations[annotation])
+		}
+	}
+
+	if _, ok := stored.Annotations[prometheusJmxExporterConfigHashAnnotationKey]; !ok {
+		t.Error("config hash annotation not found")
+	}
+}
+
+func TestProcessPodAllJavaContainers(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app", "sidecar", "proxy")
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "app")] = "42 com.example.App\n"
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "sidecar")] = "7 com.example.Sidecar\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.ContainerSelector = &v1alpha1.ContainerSelector{AllJavaContainers: true}
+
+	if err := handler.processPod(pod, testConfig, prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the containers share the network namespace thus the agents listen on distinct ports
+	if agents := executor.LoadedAgents[fakeContainerKey("default", "app-1", "app")]; !reflect.DeepEqual(agents, []string{"42:9020"}) {
+		t.Errorf("expected agent loaded into app on port 9020, got %v", agents)
+	}
+	if agents := executor.LoadedAgents[fakeContainerKey("default", "app-1", "sidecar")]; !reflect.DeepEqual(agents, []string{"7:9021"}) {
+		t.Errorf("expected agent loaded into sidecar on port 9021, got %v", agents)
+	}
+	if agents := executor.LoadedAgents[fakeContainerKey("default", "app-1", "proxy")]; len(agents) > 0 {
+		t.Errorf("expected no agent loaded into proxy, got %v", agents)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if endpoints := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; endpoints != "app/42:9020,sidecar/7:9021" {
+		t.Errorf("unexpected endpoints annotation '%s'", endpoints)
+	}
+}
+
+func TestProcessPodMultipleJavaProcessesWithoutSelector(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "app")] = "42 com.example.App\n43 com.example.Worker\n"
+
+	if err := handler.processPod(pod, testConfig, newTestPrometheusJmxExporter()); err == nil {
+		t.Fatal("expected error for multiple java processes without processSelector")
+	}
+	if len(executor.LoadedAgents) > 0 {
+		t.Errorf("expected no agent loaded, got %v", executor.LoadedAgents)
+	}
+}
+
+func TestLoadPrometheusJmxExporterAgent(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "app-1"
+	container := &v1.Container{Name: "app"}
+	key := fakeContainerKey("default", "app-1", "app")
+
+	tests := []struct {
+		name      string
+		javaErr   error
+		javaOut   string
+		expectErr bool
+		attacher  bool
+	}{
+		{name: "java loader"},
+		{name: "java not found", javaErr: fmt.Errorf("command terminated with exit code 127"), attacher: true},
+		{name: "no attach API", javaOut: "Exception in thread \"main\" java.lang.NoClassDefFoundError: com/sun/tools/attach/VirtualMachine", attacher: true},
+		{name: "loader failed", javaErr: fmt.Errorf("command terminated with exit code 1, stderr: java.net.BindException: Address already in use"), expectErr: true},
+	}
+
+	for _, test := range tests {
+		executor := newFakePodExecutor()
+		if test.javaErr != nil {
+			executor.Errors["java"] = test.javaErr
+		}
+		if len(test.javaOut) > 0 {
+			executor.Stderr["java"] = test.javaOut
+		}
+
+		err := loadPrometheusJmxExporterAgent(executor, pod, container, 9020, "42")
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			if len(executor.LoadedAgents[key]) > 0 {
+				t.Errorf("%s: expected no agent loaded, got %v", test.name, executor.LoadedAgents[key])
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if agents := executor.LoadedAgents[key]; !reflect.DeepEqual(agents, []string{"42:9020"}) {
+			t.Errorf("%s: expected agent loaded into process 42 on port 9020, got %v", test.name, agents)
+		}
+
+		attached := false
+		for _, command := range executor.Commands[key] {
+			attached = attached || strings.HasPrefix(command, path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAttacherBinary))
+		}
+		if attached != test.attacher {
+			t.Errorf("%s: expected native attacher used %v, got %v", test.name, test.attacher, attached)
+		}
+	}
+}
+
+func TestProcessPodRetrySkipsAttachedContainers(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app", "sidecar")
+	appKey := fakeContainerKey("default", "app-1", "app")
+	sidecarKey := fakeContainerKey("default", "app-1", "sidecar")
+	executor.JpsOutput[appKey] = "42 com.example.App\n"
+	executor.JpsOutput[sidecarKey] = "7 com.example.Sidecar\n8 com.example.Worker\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.ContainerSelector = &v1alpha1.ContainerSelector{AllJavaContainers: true}
+
+	if err := handler.processPod(pod, testConfig, prometheusJmxExporter); err == nil {
+		t.Fatal("expected error for multiple java processes in sidecar")
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if endpoints := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; endpoints != "app/42:9020" {
+		t.Errorf("expected the agent loaded into app recorded, got '%s'", endpoints)
+	}
+
+	executor.JpsOutput[sidecarKey] = "7 com.example.Sidecar\n"
+
+	if err := handler.processPod(&stored, testConfig, prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if agents := executor.LoadedAgents[appKey]; !reflect.DeepEqual(agents, []string{"42:9020"}) {
+		t.Errorf("expected agent loaded into app once, got %v", agents)
+	}
+	if agents := executor.LoadedAgents[sidecarKey]; !reflect.DeepEqual(agents, []string{"7:9021"}) {
+		t.Errorf("expected agent loaded into sidecar on port 9021, got %v", agents)
+	}
+
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if endpoints := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; endpoints != "app/42:9020,sidecar/7:9021" {
+		t.Errorf("unexpected endpoints annotation '%s'", endpoints)
+	}
+
+	// the agent is lost when the container restarts thus it's loaded again
+	stored.Status.ContainerStatuses[0].RestartCount++
+	delete(stored.Annotations, prometheusJmxExporterAnnotationKey)
+
+	if err := handler.processPod(&stored, testConfig, prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if agents := executor.LoadedAgents[appKey]; !reflect.DeepEqual(agents, []string{"42:9020", "42:9020"}) {
+		t.Errorf("expected agent loaded into restarted app again, got %v", agents)
+	}
+	if agents := executor.LoadedAgents[sidecarKey]; len(agents) != 1 {
+		t.Errorf("expected agent loaded into sidecar once, got %v", agents)
+	}
+}
@@ -0,0 +1,264 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// patchOperation is a JSON patch (RFC 6902) operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// createInjectionPatch returns the JSON patch operations which make the containers of pod selected by spec
+// to start their java process with the prometheus jmx exporter agent loaded through -javaagent.
+// An init container copies the jars and config, stored in the secret named configSecretName, into an
+// emptyDir volume that is mounted into the containers at prometheusJmxExporterTargetDir.
+func createInjectionPatch(pod *v1.Pod, config *v1alpha1.PrometheusJmxExporterConfig, spec *v1alpha1.PrometheusJmxExporterSpec,
+	configSecretName, injectorImage string) ([]patchOperation, error) {
+	// the java processes are not running yet, thus all containers are injected in case of AllJavaContainers
+	containers, err := selectContainers(pod, spec.ContainerSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(containers) == 0 {
+		return nil, nil
+	}
+
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch []patchOperation
+
+	patch = append(patch, addToSlice(
+		"/spec/volumes",
+		len(pod.Spec.Volumes) == 0,
+		v1.Volume{
+			Name:         prometheusJmxExporterVolumeName,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		}))
+
+	patch = append(patch, addToSlice(
+		"/spec/volumes",
+		false,
+		v1.Volume{
+			Name:         prometheusJmxExporterConfigVolumeName,
+			VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: configSecretName}},
+		}))
+
+	patch = append(patch, addToSlice(
+		"/spec/initContainers",
+		len(pod.Spec.InitContainers) == 0,
+		createInjectorInitContainer(injectorImage)))
+
+	reservedPorts := make(map[int]bool)
+	var endpoints []*v1alpha1.MetricsEndpoint
+
+	for i := 0; i < len(pod.Spec.Containers); i++ {
+		container := &pod.Spec.Containers[i]
+
+		if !containsContainer(containers, container.Name) {
+			continue
+		}
+
+		portNumber, err := selectPortForNewPod(pod, spec, reservedPorts)
+		if err != nil {
+			return nil, err
+		}
+
+		containerPatch, err := createContainerInjectionPatch(i, container, portNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		patch = append(patch, containerPatch...)
+
+		reservedPorts[portNumber] = true
+		endpoints = append(endpoints, &v1alpha1.MetricsEndpoint{
+			Container: container.Name,
+			Port:      portNumber,
+		})
+	}
+
+	patch = append(patch, addToMap("/metadata/annotations", pod.Annotations, map[string]string{
+		prometheusJmxExporterAnnotationKey:           prometheusJmxExporterAnnotationVerified,
+		prometheusScrapeAnnotationKey:                "true",
+		prometheusPortAnnotationKey:                  strconv.Itoa(endpoints[0].Port),
+		prometheusJmxExporterEndpointsAnnotationKey:  formatEndpointsAnnotation(endpoints),
+		prometheusJmxExporterConfigHashAnnotationKey: hash,
+		prometheusJmxExporterAttachTimeAnnotationKey: time.Now().UTC().Format(time.RFC3339),
+	})...)
+
+	return patch, nil
+}
+
+// createContainerInjectionPatch returns the JSON patch operations which mount the shared volume into
+// the container at index idx, expose portNumber and append -javaagent to JAVA_TOOL_OPTIONS
+func createContainerInjectionPatch(idx int, container *v1.Container, portNumber int) ([]patchOperation, error) {
+	for _, port := range container.Ports {
+		if port.ContainerPort == int32(portNumber) {
+			return nil, fmt.Errorf("port number %d is already in use", portNumber)
+		}
+	}
+
+	containerPath := fmt.Sprintf("/spec/containers/%d", idx)
+
+	var patch []patchOperation
+
+	patch = append(patch, addToSlice(
+		containerPath+"/volumeMounts",
+		len(container.VolumeMounts) == 0,
+		v1.VolumeMount{
+			Name:      prometheusJmxExporterVolumeName,
+			MountPath: prometheusJmxExporterTargetDir,
+		}))
+
+	patch = append(patch, addToSlice(
+		containerPath+"/ports",
+		len(container.Ports) == 0,
+		v1.ContainerPort{
+			ContainerPort: int32(portNumber),
+			Name:          prometheusJmxExporterContainerPortName,
+			Protocol:      v1.ProtocolTCP,
+		}))
+
+	javaAgentOption := createJavaAgentOption(portNumber)
+
+	envIdx := -1
+	for i, env := range container.Env {
+		if env.Name == javaToolOptionsEnvName {
+			envIdx = i
+			break
+		}
+	}
+
+	if envIdx < 0 {
+		patch = append(patch, addToSlice(
+			containerPath+"/env",
+			len(container.Env) == 0,
+			v1.EnvVar{
+				Name:  javaToolOptionsEnvName,
+				Value: javaAgentOption,
+			}))
+	} else {
+		if container.Env[envIdx].ValueFrom != nil {
+			return nil, fmt.Errorf("%s of container '%s' is set from a source, -javaagent can not be appended to it",
+				javaToolOptionsEnvName, container.Name)
+		}
+
+		patch = append(patch, patchOperation{
+			Op:    "replace",
+			Path:  fmt.Sprintf("%s/env/%d/value", containerPath, envIdx),
+			Value: strings.TrimSpace(container.Env[envIdx].Value + " " + javaAgentOption),
+		})
+	}
+
+	return patch, nil
+}
+
+// containsContainer returns true if containers has a container with the given name
+func containsContainer(containers []v1.Container, name string) bool {
+	for _, container := range containers {
+		if container.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// createInjectorInitContainer returns the init container which copies the prometheus jmx exporter
+// jars shipped with the operator image and the config mounted from its secret to the shared volume
+func createInjectorInitContainer(injectorImage string) v1.Container {
+	confDir := path.Join(prometheusJmxExporterInjectorDir, prometheusJmxExporterTargetConfDir)
+
+	script := fmt.Sprintf("cp %s %s && mkdir -p %s && cp %s %s",
+		path.Join(prometheusJmxExporterSrcJarsDir, "*.jar"),
+		prometheusJmxExporterInjectorDir,
+		confDir,
+		path.Join(prometheusJmxExporterInjectorConfigDir, prometheusJmxExportedConfigFilename),
+		path.Join(confDir, prometheusJmxExportedConfigFilename))
+
+	return v1.Container{
+		Name:    prometheusJmxExporterInitContainerName,
+		Image:   injectorImage,
+		Command: []string{"sh", "-c", script},
+		VolumeMounts: []v1.VolumeMount{
+			{
+				Name:      prometheusJmxExporterVolumeName,
+				MountPath: prometheusJmxExporterInjectorDir,
+			},
+			{
+				Name:      prometheusJmxExporterConfigVolumeName,
+				MountPath: prometheusJmxExporterInjectorConfigDir,
+				ReadOnly:  true,
+			},
+		},
+	}
+}
+
+// createJavaAgentOption returns the -javaagent option that loads prometheus jmx exporter agent
+func createJavaAgentOption(portNumber int) string {
+	return fmt.Sprintf("-javaagent:%s=%d:%s",
+		path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAgentJar),
+		portNumber,
+		path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir, prometheusJmxExportedConfigFilename))
+}
+
+// addToSlice returns the patch operation which appends value to the array at path.
+// If the array doesn't exist yet it is created.
+func addToSlice(path string, empty bool, value interface{}) patchOperation {
+	if empty {
+		return patchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: []interface{}{value},
+		}
+	}
+
+	return patchOperation{
+		Op:    "add",
+		Path:  path + "/-",
+		Value: value,
+	}
+}
+
+// addToMap returns the patch operations which add values to the map at path.
+// If the map doesn't exist yet it is created.
+func addToMap(path string, current map[string]string, values map[string]string) []patchOperation {
+	if current == nil {
+		return []patchOperation{
+			{
+				Op:    "add",
+				Path:  path,
+				Value: values,
+			},
+		}
+	}
+
+	var patch []patchOperation
+	for key, value := range values {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  path + "/" + escapeJSONPointer(key),
+			Value: value,
+		})
+	}
+
+	return patch
+}
+
+// escapeJSONPointer escapes s to be used as a JSON pointer (RFC 6901) reference token
+func escapeJSONPointer(s string) string {
+	return strings.Replace(strings.Replace(s, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,158 @@
+package stub
+
+import (
+	"encoding/json"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/ghodss/yaml"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContainerPortNameIsValid(t *testing.T) {
+	if errs := validation.IsValidPortName(prometheusJmxExporterContainerPortName); len(errs) > 0 {
+		t.Errorf("invalid container port name '%s': %v", prometheusJmxExporterContainerPortName, errs)
+	}
+}
+
+func TestCreateContainerInjectionPatchExposesValidPort(t *testing.T) {
+	container := &v1.Container{
+		Name:  "app",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+	}
+
+	patch, err := createContainerInjectionPatch(0, container, 9020)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var port *v1.ContainerPort
+	for _, operation := range patch {
+		if p, ok := operation.Value.(v1.ContainerPort); ok && operation.Path == "/spec/containers/0/ports/-" {
+			port = &p
+		}
+	}
+
+	if port == nil {
+		t.Fatalf("no port added by patch %v", patch)
+	}
+	if port.ContainerPort != 9020 {
+		t.Errorf("expected port 9020, got %d", port.ContainerPort)
+	}
+	if errs := validation.IsValidPortName(port.Name); len(errs) > 0 {
+		t.Errorf("invalid port name '%s': %v", port.Name, errs)
+	}
+}
+
+func TestCreateContainerInjectionPatchRejectsUsedPort(t *testing.T) {
+	container := &v1.Container{
+		Name:  "app",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 9020}},
+	}
+
+	if _, err := createContainerInjectionPatch(0, container, 9020); err == nil {
+		t.Error("expected error for a port declared by the container")
+	}
+}
+
+func TestCreateInjectionPatchMountsConfigFromSecret(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Spec.Containers = []v1.Container{{Name: "app"}}
+
+	spec := &newTestPrometheusJmxExporter().Spec
+	patch, err := createInjectionPatch(pod, testConfig, spec, "test-jmx-exporter-config", "operator:latest")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var secretVolume *v1.Volume
+	var initContainer *v1.Container
+	for _, operation := range patch {
+		switch value := operation.Value.(type) {
+		case v1.Volume:
+			if operation.Path == "/spec/volumes/-" {
+				secretVolume = &value
+			}
+		case []interface{}:
+			if c, ok := value[0].(v1.Container); ok && operation.Path == "/spec/initContainers" {
+				initContainer = &c
+			}
+		}
+	}
+
+	if secretVolume == nil || secretVolume.Secret == nil || secretVolume.Secret.SecretName != "test-jmx-exporter-config" {
+		t.Fatalf("expected config secret volume appended to the emptyDir volume, got patch %v", patch)
+	}
+	if initContainer == nil {
+		t.Fatalf("no init container added by patch %v", patch)
+	}
+
+	// the config, which may hold credentials, is not part of the pod spec
+	if len(initContainer.Env) > 0 {
+		t.Errorf("expected no environment variables in the init container, got %v", initContainer.Env)
+	}
+
+	var mounted bool
+	for _, mount := range initContainer.VolumeMounts {
+		if mount.Name == secretVolume.Name {
+			mounted = mount.ReadOnly && mount.MountPath == prometheusJmxExporterInjectorConfigDir
+		}
+	}
+	if !mounted {
+		t.Errorf("expected config secret mounted read-only at %s, got %v", prometheusJmxExporterInjectorConfigDir, initContainer.VolumeMounts)
+	}
+	if script := strings.Join(initContainer.Command, " "); !strings.Contains(script, prometheusJmxExporterInjectorConfigDir+"/"+prometheusJmxExportedConfigFilename) {
+		t.Errorf("expected config copied from the secret, got '%s'", script)
+	}
+}
+
+func TestMutatePodStoresConfigInSecret(t *testing.T) {
+	api := installFakeAPI()
+
+	password := "s3cr3t"
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Mode = v1alpha1.InjectionModeStartup
+	prometheusJmxExporter.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{
+		Username: stringPtr("monitor"),
+		Password: &password,
+	}
+	installFakeCache(t, prometheusJmxExporter)
+
+	pod := v1.Pod{}
+	pod.GenerateName = "app-"
+	pod.Labels = map[string]string{"app": "test"}
+	pod.Spec.Containers = []v1.Container{{Name: "app", Image: "openjdk:8"}}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("encoding pod failed: %v", err)
+	}
+
+	server := NewWebhookServer(":8443", "", "", "operator:latest")
+	response := server.mutatePod(&v1beta1.AdmissionRequest{Operation: v1beta1.Create, Namespace: "default", Object: runtime.RawExtension{Raw: raw}})
+
+	if !response.Allowed || len(response.Patch) == 0 {
+		t.Fatalf("expected pod admitted with patch, got %v", response)
+	}
+	if strings.Contains(string(response.Patch), password) {
+		t.Errorf("expected the password of the config kept out of the pod spec, got patch %s", response.Patch)
+	}
+
+	var secret v1.Secret
+	api.get(t, "secrets", "default", "test-jmx-exporter-config", &secret)
+
+	var stored v1alpha1.PrometheusJmxExporterConfig
+	if err := yaml.Unmarshal(secret.Data[prometheusJmxExportedConfigFilename], &stored); err != nil {
+		t.Fatalf("decoding config of secret failed: %v", err)
+	}
+	if !reflect.DeepEqual(&stored, prometheusJmxExporter.Spec.Config.Inline) {
+		t.Errorf("expected config stored in secret, got %s", secret.Data[prometheusJmxExportedConfigFilename])
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Name != "test" {
+		t.Errorf("expected secret owned by the prometheusjmxexporter, got %v", secret.OwnerReferences)
+	}
+}
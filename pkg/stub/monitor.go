@@ -0,0 +1,383 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"regexp"
+)
+
+const (
+	monitoringAPIVersion   = "monitoring.coreos.com/v1"
+	monitorLabelKey        = "prometheus-jmx-exporter"
+	monitorServicePortName = "jmx-metrics"
+	monitorMetricsPath     = "/metrics"
+	// podMonitorMaxEndpoints is the number of endpoints per pod the PodMonitor scrapes if multiple containers or
+	// java processes of a pod can be instrumented
+	podMonitorMaxEndpoints = 10
+)
+
+// annotationLabelRegexp matches the characters of an annotation key which are replaced by underscores in the
+// name of the meta label holding the annotation in Prometheus
+var annotationLabelRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// monitorEndpoint is the scrape endpoint of a PodMonitor or a ServiceMonitor
+type monitorEndpoint struct {
+	Port          string                   `json:"port,omitempty"`
+	Path          string                   `json:"path,omitempty"`
+	Interval      string                   `json:"interval,omitempty"`
+	ScrapeTimeout string                   `json:"scrapeTimeout,omitempty"`
+	Relabelings   []v1alpha1.RelabelConfig `json:"relabelings,omitempty"`
+}
+
+// monitorSpec is the spec of a PodMonitor or a ServiceMonitor
+type monitorSpec struct {
+	Selector          metav1.LabelSelector `json:"selector"`
+	NamespaceSelector struct {
+		MatchNames []string `json:"matchNames"`
+	} `json:"namespaceSelector"`
+	PodMetricsEndpoints []monitorEndpoint `json:"podMetricsEndpoints,omitempty"`
+	Endpoints           []monitorEndpoint `json:"endpoints,omitempty"`
+}
+
+// syncMonitor creates or updates the Prometheus Operator resources requested by the monitor section of
+// prometheusJmxExporter and deletes the ones of the other kind. The resources are owned by prometheusJmxExporter
+// thus they are garbage collected when it's deleted.
+func syncMonitor(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) error {
+	switch prometheusJmxExporter.Spec.MonitorKind() {
+	case v1alpha1.MonitorKindPodMonitor:
+		podMonitor, err := newPodMonitor(prometheusJmxExporter)
+		if err != nil {
+			return err
+		}
+		if err := applyMonitor(podMonitor); err != nil {
+			return err
+		}
+
+		return deleteOwnedObjects(prometheusJmxExporter,
+			newMonitorObject(prometheusJmxExporter, v1alpha1.MonitorKindServiceMonitor),
+			newMonitorService(prometheusJmxExporter))
+	case v1alpha1.MonitorKindServiceMonitor:
+		if prometheusJmxExporter.Spec.PortRange != nil {
+			return fmt.Errorf("monitor kind '%s' requires a fixed port, use '%s' together with portRange",
+				v1alpha1.MonitorKindServiceMonitor, v1alpha1.MonitorKindPodMonitor)
+		}
+		if len(prometheusJmxExporter.Spec.PodSelector().MatchExpressions) > 0 {
+			return fmt.Errorf("monitor kind '%s' can not select pods by matchExpressions, use '%s' instead",
+				v1alpha1.MonitorKindServiceMonitor, v1alpha1.MonitorKindPodMonitor)
+		}
+
+		serviceMonitor, err := newServiceMonitor(prometheusJmxExporter)
+		if err != nil {
+			return err
+		}
+		if err := applyMonitorService(newMonitorService(prometheusJmxExporter)); err != nil {
+			return err
+		}
+		if err := applyMonitor(serviceMonitor); err != nil {
+			return err
+		}
+
+		return deleteOwnedObjects(prometheusJmxExporter,
+			newMonitorObject(prometheusJmxExporter, v1alpha1.MonitorKindPodMonitor))
+	case "":
+		// the Prometheus Operator may not be installed, thus failing to look up its resources is not an error
+		if err := deleteOwnedObjects(prometheusJmxExporter,
+			newMonitorObject(prometheusJmxExporter, v1alpha1.MonitorKindPodMonitor),
+			newMonitorObject(prometheusJmxExporter, v1alpha1.MonitorKindServiceMonitor)); err != nil {
+			logrus.Debugf("Deleting monitors of '%s/%s' failed: %v",
+				prometheusJmxExporter.Namespace, prometheusJmxExporter.Name, err)
+		}
+
+		return deleteOwnedObjects(prometheusJmxExporter, newMonitorService(prometheusJmxExporter))
+	default:
+		return fmt.Errorf("unknown monitor kind '%s'", prometheusJmxExporter.Spec.Monitor.Kind)
+	}
+}
+
+// monitorName returns the name of the resources created for the monitor section of prometheusJmxExporter
+func monitorName(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) string {
+	return prometheusJmxExporter.Name + "-jmx-exporter"
+}
+
+// monitorLabels returns the labels of the resources created for the monitor section of prometheusJmxExporter
+func monitorLabels(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) map[string]string {
+	labels := make(map[string]string)
+	if prometheusJmxExporter.Spec.Monitor != nil {
+		for key, value := range prometheusJmxExporter.Spec.Monitor.Labels {
+			labels[key] = value
+		}
+	}
+	labels[monitorLabelKey] = prometheusJmxExporter.Name
+
+	return labels
+}
+
+// newOwnerReference returns the owner reference that binds the lifecycle of an object to prometheusJmxExporter
+func newOwnerReference(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) metav1.OwnerReference {
+	controller := true
+	blockOwnerDeletion := true
+
+	if clusterOwner := prometheusJmxExporter.ClusterOwner(); clusterOwner != nil {
+		ownerReference := *clusterOwner
+		ownerReference.BlockOwnerDeletion = &blockOwnerDeletion
+		return ownerReference
+	}
+
+	return metav1.OwnerReference{
+		APIVersion:         v1alpha1.SchemeGroupVersion.String(),
+		Kind:               v1alpha1.PrometheusJmxExporterKind,
+		Name:               prometheusJmxExporter.Name,
+		UID:                prometheusJmxExporter.UID,
+		Controller:         &controller,
+		BlockOwnerDeletion: &blockOwnerDeletion,
+	}
+}
+
+// newMonitorObject returns an empty monitor resource of kind identified by the name and namespace
+// it has on behalf of prometheusJmxExporter
+func newMonitorObject(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, kind string) *unstructured.Unstructured {
+	monitor := &unstructured.Unstructured{}
+	monitor.SetAPIVersion(monitoringAPIVersion)
+	monitor.SetKind(kind)
+	monitor.SetName(monitorName(prometheusJmxExporter))
+	monitor.SetNamespace(prometheusJmxExporter.Namespace)
+
+	return monitor
+}
+
+// newMonitorEndpoint returns the scrape endpoint targeting port as configured by the monitor section of prometheusJmxExporter
+func newMonitorEndpoint(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, port string) monitorEndpoint {
+	monitor := prometheusJmxExporter.Spec.Monitor
+
+	return monitorEndpoint{
+		Port:          port,
+		Path:          monitorMetricsPath,
+		Interval:      monitor.Interval,
+		ScrapeTimeout: monitor.ScrapeTimeout,
+		Relabelings:   monitor.Relabelings,
+	}
+}
+
+// newPodMonitor returns the PodMonitor which scrapes the endpoints of the pods selected by prometheusJmxExporter.
+// The ports exposed in attach mode are not declared by the containers of the running pods, thus the address of
+// each endpoint is taken from the endpoints annotation of the pod instead of a container port.
+func newPodMonitor(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) (*unstructured.Unstructured, error) {
+	spec := monitorSpec{
+		Selector: *prometheusJmxExporter.Spec.PodSelector(),
+	}
+	spec.NamespaceSelector.MatchNames = []string{prometheusJmxExporter.Namespace}
+
+	for i := 0; i < podMonitorEndpointCount(&prometheusJmxExporter.Spec); i++ {
+		endpoint := newMonitorEndpoint(prometheusJmxExporter, "")
+		endpoint.Relabelings = append(podMonitorEndpointRelabelings(i), endpoint.Relabelings...)
+
+		spec.PodMetricsEndpoints = append(spec.PodMetricsEndpoints, endpoint)
+	}
+
+	return newMonitor(prometheusJmxExporter, v1alpha1.MonitorKindPodMonitor, &spec)
+}
+
+// podMonitorEndpointCount returns the number of endpoints per pod the PodMonitor of spec scrapes
+func podMonitorEndpointCount(spec *v1alpha1.PrometheusJmxExporterSpec) int {
+	containerSelector := spec.ContainerSelector
+	if spec.ProcessSelector == nil && (containerSelector == nil || (!containerSelector.AllJavaContainers && len(containerSelector.NameRegex) == 0)) {
+		return 1
+	}
+
+	return podMonitorMaxEndpoints
+}
+
+// podMonitorEndpointRelabelings returns the relabelings which keep a single target of the pods having an endpoint
+// at index in their endpoints annotation and point it to the port of that endpoint. Prometheus discovers a target for
+// each container and port of a pod, these targets become identical by the relabelings thus the endpoint is scraped once.
+func podMonitorEndpointRelabelings(index int) []v1alpha1.RelabelConfig {
+	endpointsLabel := annotationLabel(prometheusJmxExporterEndpointsAnnotationKey)
+
+	// the endpoints annotation is in <container>[/<pid>]:<port>[,<container>[/<pid>]:<port>...] format
+	endpointRegex := `([^,/:]*)(?:/[^,:]*)?:(\d+)(?:,.*)?`
+	if index > 0 {
+		endpointRegex = fmt.Sprintf(`(?:[^,]*,){%d}`, index) + endpointRegex
+	}
+
+	return []v1alpha1.RelabelConfig{
+		{
+			SourceLabels: []string{endpointsLabel},
+			Regex:        endpointRegex,
+			Action:       "keep",
+		},
+		{
+			SourceLabels: []string{"__meta_kubernetes_pod_ip", endpointsLabel},
+			Separator:    ";",
+			Regex:        "(.+);" + endpointRegex,
+			Replacement:  "$1:$3",
+			TargetLabel:  "__address__",
+			Action:       "replace",
+		},
+		{
+			SourceLabels: []string{endpointsLabel},
+			Regex:        endpointRegex,
+			Replacement:  "$1",
+			TargetLabel:  "container",
+			Action:       "replace",
+		},
+	}
+}
+
+// annotationLabel returns the name of the meta label holding the pod annotation key in Prometheus
+func annotationLabel(key string) string {
+	return "__meta_kubernetes_pod_annotation_" + annotationLabelRegexp.ReplaceAllString(key, "_")
+}
+
+// newServiceMonitor returns the ServiceMonitor which scrapes the service created by newMonitorService
+func newServiceMonitor(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) (*unstructured.Unstructured, error) {
+	spec := monitorSpec{
+		Selector: metav1.LabelSelector{MatchLabels: map[string]string{monitorLabelKey: prometheusJmxExporter.Name}},
+		Endpoints: []monitorEndpoint{
+			newMonitorEndpoint(prometheusJmxExporter, monitorServicePortName),
+		},
+	}
+	spec.NamespaceSelector.MatchNames = []string{prometheusJmxExporter.Namespace}
+
+	return newMonitor(prometheusJmxExporter, v1alpha1.MonitorKindServiceMonitor, &spec)
+}
+
+// newMonitor returns the monitor resource of kind with spec owned by prometheusJmxExporter
+func newMonitor(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, kind string, spec *monitorSpec) (*unstructured.Unstructured, error) {
+	monitor := newMonitorObject(prometheusJmxExporter, kind)
+	monitor.SetLabels(monitorLabels(prometheusJmxExporter))
+	monitor.SetOwnerReferences([]metav1.OwnerReference{newOwnerReference(prometheusJmxExporter)})
+
+	specData, err := runtime.DefaultUnstructuredConverter.ToUnstructured(spec)
+	if err != nil {
+		return nil, fmt.Errorf("converting spec of %s '%s/%s' failed: %v", kind, monitor.GetNamespace(), monitor.GetName(), err)
+	}
+	monitor.Object["spec"] = specData
+
+	return monitor, nil
+}
+
+// newMonitorService returns the headless service which selects the pods of prometheusJmxExporter
+// for the ServiceMonitor
+func newMonitorService(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) *v1.Service {
+	return &v1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            monitorName(prometheusJmxExporter),
+			Namespace:       prometheusJmxExporter.Namespace,
+			Labels:          monitorLabels(prometheusJmxExporter),
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(prometheusJmxExporter)},
+		},
+		Spec: v1.ServiceSpec{
+			ClusterIP: v1.ClusterIPNone,
+			Selector:  prometheusJmxExporter.Spec.PodSelector().MatchLabels,
+			Ports: []v1.ServicePort{
+				{
+					Name:       monitorServicePortName,
+					Protocol:   v1.ProtocolTCP,
+					Port:       int32(prometheusJmxExporter.Spec.Port),
+					TargetPort: intstr.FromInt(prometheusJmxExporter.Spec.Port),
+				},
+			},
+		},
+	}
+}
+
+// applyMonitor creates monitor or updates its labels and spec if it already exists
+func applyMonitor(monitor *unstructured.Unstructured) error {
+	existing := &unstructured.Unstructured{}
+	existing.SetAPIVersion(monitor.GetAPIVersion())
+	existing.SetKind(monitor.GetKind())
+	existing.SetName(monitor.GetName())
+	existing.SetNamespace(monitor.GetNamespace())
+
+	err := getObject(existing)
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("Creating %s '%s/%s'", monitor.GetKind(), monitor.GetNamespace(), monitor.GetName())
+		return createObject(monitor)
+	}
+	if err != nil {
+		return err
+	}
+
+	existing.SetLabels(monitor.GetLabels())
+	existing.SetOwnerReferences(monitor.GetOwnerReferences())
+	existing.Object["spec"] = monitor.Object["spec"]
+
+	return updateObject(existing)
+}
+
+// applyMonitorService creates service or updates its labels, selector and ports if it already exists
+func applyMonitorService(service *v1.Service) error {
+	existing := &v1.Service{
+		TypeMeta:   service.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{Name: service.Name, Namespace: service.Namespace},
+	}
+
+	err := getObject(existing)
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("Creating service '%s/%s'", service.Namespace, service.Name)
+		return createObject(service)
+	}
+	if err != nil {
+		return err
+	}
+
+	existing.Labels = service.Labels
+	existing.OwnerReferences = service.OwnerReferences
+	existing.Spec.Selector = service.Spec.Selector
+	existing.Spec.Ports = service.Spec.Ports
+
+	return updateObject(existing)
+}
+
+// deleteOwnedObjects deletes those of objects that exist and are owned by prometheusJmxExporter
+func deleteOwnedObjects(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, objects ...runtime.Object) error {
+	for _, object := range objects {
+		err := getObject(object)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		accessor, err := meta.Accessor(object)
+		if err != nil {
+			return err
+		}
+
+		if !isOwnedBy(accessor, prometheusJmxExporter) {
+			continue
+		}
+
+		logrus.Infof("Deleting %s '%s/%s'", object.GetObjectKind().GroupVersionKind().Kind, accessor.GetNamespace(), accessor.GetName())
+
+		if err := deleteObject(object); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isOwnedBy returns true if object has an owner reference to prometheusJmxExporter
+func isOwnedBy(object metav1.Object, prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) bool {
+	for _, ownerReference := range object.GetOwnerReferences() {
+		if ownerReference.UID == prometheusJmxExporter.UID {
+			return true
+		}
+	}
+
+	return false
+}
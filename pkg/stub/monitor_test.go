@@ -0,0 +1,108 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// relabel applies relabelings to the labels of a target the way Prometheus does for the keep and replace actions.
+// Returns false if the target is dropped.
+func relabel(labels map[string]string, relabelings []v1alpha1.RelabelConfig) bool {
+	for _, relabeling := range relabelings {
+		var values []string
+		for _, label := range relabeling.SourceLabels {
+			values = append(values, labels[label])
+		}
+		separator := relabeling.Separator
+		if len(separator) == 0 {
+			separator = ";"
+		}
+		value := strings.Join(values, separator)
+
+		// Prometheus anchors the regular expressions of relabelings
+		regex := regexp.MustCompile("^(?:" + relabeling.Regex + ")$")
+		match := regex.FindStringSubmatchIndex(value)
+
+		switch relabeling.Action {
+		case "keep":
+			if match == nil {
+				return false
+			}
+		case "replace":
+			if match != nil {
+				labels[relabeling.TargetLabel] = string(regex.ExpandString(nil, relabeling.Replacement, value, match))
+			}
+		}
+	}
+
+	return true
+}
+
+func TestPodMonitorEndpointRelabelings(t *testing.T) {
+	tests := []struct {
+		endpoints string
+		index     int
+		kept      bool
+		address   string
+		container string
+	}{
+		{endpoints: "app/42:9020", index: 0, kept: true, address: "10.0.0.1:9020", container: "app"},
+		{endpoints: "app:9020", index: 0, kept: true, address: "10.0.0.1:9020", container: "app"},
+		{endpoints: "app/42:9020,sidecar/7:9021", index: 1, kept: true, address: "10.0.0.1:9021", container: "sidecar"},
+		{endpoints: "app/42:9020", index: 1},
+		{endpoints: "", index: 0},
+	}
+
+	for _, test := range tests {
+		labels := map[string]string{
+			"__address__":              "10.0.0.1:8080",
+			"__meta_kubernetes_pod_ip": "10.0.0.1",
+			"container":                "proxy",
+			annotationLabel(prometheusJmxExporterEndpointsAnnotationKey): test.endpoints,
+		}
+
+		kept := relabel(labels, podMonitorEndpointRelabelings(test.index))
+		if kept != test.kept {
+			t.Errorf("'%s' at %d: expected kept %v, got %v", test.endpoints, test.index, test.kept, kept)
+			continue
+		}
+		if !kept {
+			continue
+		}
+
+		if labels["__address__"] != test.address || labels["container"] != test.container {
+			t.Errorf("'%s' at %d: expected %s of %s, got %s of %s", test.endpoints, test.index,
+				test.address, test.container, labels["__address__"], labels["container"])
+		}
+	}
+}
+
+func TestNewPodMonitor(t *testing.T) {
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Monitor = &v1alpha1.MonitorSpec{Kind: v1alpha1.MonitorKindPodMonitor}
+
+	podMonitor, err := newPodMonitor(prometheusJmxExporter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	endpoints := podMonitor.Object["spec"].(map[string]interface{})["podMetricsEndpoints"].([]interface{})
+	if len(endpoints) != 1 {
+		t.Fatalf("expected 1 endpoint, got %d", len(endpoints))
+	}
+	if _, ok := endpoints[0].(map[string]interface{})["port"]; ok {
+		t.Error("expected endpoint without port as the exposed ports are not declared in attach mode")
+	}
+
+	prometheusJmxExporter.Spec.ContainerSelector = &v1alpha1.ContainerSelector{AllJavaContainers: true}
+
+	podMonitor, err = newPodMonitor(prometheusJmxExporter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if endpoints := podMonitor.Object["spec"].(map[string]interface{})["podMetricsEndpoints"].([]interface{}); len(endpoints) != podMonitorMaxEndpoints {
+		t.Errorf("expected %d endpoints, got %d", podMonitorMaxEndpoints, len(endpoints))
+	}
+}
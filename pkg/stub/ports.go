@@ -0,0 +1,163 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+)
+
+// tcpListenState is the state of listening sockets in /proc/net/tcp
+const tcpListenState = "0A"
+
+// maxPort is the highest TCP port number
+const maxPort = 65535
+
+// selectPort returns the port number the prometheus jmx exporter agent will listen on in container of pod.
+// reservedPorts are the ports already assigned to the agents loaded into the other containers and processes of pod.
+// The port is neither reserved, declared by the containers of pod nor used by a listening socket inside container.
+// If spec has no port range it's Port, see selectFixedPort, otherwise the first free port of the range.
+func selectPort(executor PodExecutor, pod *v1.Pod, container *v1.Container, spec *v1alpha1.PrometheusJmxExporterSpec, reservedPorts map[int]bool) (int, error) {
+	usedPorts, err := queryListeningPorts(executor, pod, container)
+	if err != nil {
+		return 0, err
+	}
+
+	// the ports exposed for agents loaded at previous attempts are reused unless the agents are still running,
+	// the ports of these are reserved
+	agentPorts := exposedAgentPorts(pod)
+	for port := range declaredPorts(pod) {
+		if !agentPorts[port] {
+			usedPorts[port] = true
+		}
+	}
+
+	if spec.PortRange == nil {
+		return selectFixedPort(spec.Port, usedPorts, reservedPorts)
+	}
+
+	for port := range reservedPorts {
+		usedPorts[port] = true
+	}
+
+	return selectFreePort(spec.PortRange, usedPorts)
+}
+
+// selectPortForNewPod returns the port number the prometheus jmx exporter agent will listen on in pod
+// which is not running yet, thus only the ports declared by its containers are considered to be in use.
+func selectPortForNewPod(pod *v1.Pod, spec *v1alpha1.PrometheusJmxExporterSpec, reservedPorts map[int]bool) (int, error) {
+	usedPorts := declaredPorts(pod)
+
+	if spec.PortRange == nil {
+		return selectFixedPort(spec.Port, usedPorts, reservedPorts)
+	}
+
+	for port := range reservedPorts {
+		usedPorts[port] = true
+	}
+
+	return selectFreePort(spec.PortRange, usedPorts)
+}
+
+// selectFixedPort returns port for the first agent of a pod, an error is returned if port is in usedPorts.
+// The containers of a pod share the network namespace thus the agents loaded into the other containers and processes
+// of the pod can not listen on the same port: as port is in reservedPorts then, these take the next port after it which
+// is neither used nor reserved. An error is returned if there is no such port up to 65535.
+func selectFixedPort(port int, usedPorts, reservedPorts map[int]bool) (int, error) {
+	if !reservedPorts[port] && usedPorts[port] {
+		return 0, fmt.Errorf("port %d is already in use, set a free port or a portRange", port)
+	}
+
+	for next := port; next <= maxPort; next++ {
+		if !usedPorts[next] && !reservedPorts[next] {
+			return next, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free port found after port %d for an additional agent, set a portRange", port)
+}
+
+// selectFreePort returns the first port from portRange that is not in usedPorts
+func selectFreePort(portRange *v1alpha1.PortRange, usedPorts map[int]bool) (int, error) {
+	for port := portRange.From; port <= portRange.To; port++ {
+		if !usedPorts[port] {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no free port found in range %d-%d", portRange.From, portRange.To)
+}
+
+// declaredPorts returns the container ports declared by the containers of pod.
+// The containers of a pod share the network namespace thus all of them are collected.
+func declaredPorts(pod *v1.Pod) map[int]bool {
+	ports := make(map[int]bool)
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			ports[int(port.ContainerPort)] = true
+		}
+	}
+
+	return ports
+}
+
+// exposedAgentPorts returns the container ports exposed for the prometheus jmx exporter agents by the operator
+func exposedAgentPorts(pod *v1.Pod) map[int]bool {
+	ports := make(map[int]bool)
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == prometheusJmxExporterContainerPortName {
+				ports[int(port.ContainerPort)] = true
+			}
+		}
+	}
+
+	return ports
+}
+
+// queryListeningPorts returns the ports of the TCP sockets being in listening state inside container
+func queryListeningPorts(executor PodExecutor, pod *v1.Pod, container *v1.Container) (map[int]bool, error) {
+	logrus.Infof("Inspecting container '%s/%s/%s' for listening ports", pod.Namespace, pod.Name, container.Name)
+
+	// /proc/net/tcp6 may not exists if IPv6 is disabled
+	stdout, err := execCommand(executor, pod.Namespace, pod.Name, nil, container,
+		"sh", "-c", "cat /proc/net/tcp /proc/net/tcp6 2>/dev/null")
+	if err != nil {
+		logrus.Warnf("Failed to retrieve listening ports: %v", err)
+		return nil, err
+	}
+
+	return parseListeningPorts(stdout), nil
+}
+
+// parseListeningPorts parses the content of /proc/net/tcp and /proc/net/tcp6 and returns the local ports of
+// the sockets in listening state
+func parseListeningPorts(procNetTcp string) map[int]bool {
+	ports := make(map[int]bool)
+
+	for _, line := range strings.Split(procNetTcp, "\n") {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[3] != tcpListenState {
+			continue
+		}
+
+		idx := strings.LastIndex(fields[1], ":")
+		if idx < 0 {
+			continue
+		}
+
+		port, err := strconv.ParseInt(fields[1][idx+1:], 16, 32)
+		if err != nil {
+			continue
+		}
+
+		ports[int(port)] = true
+	}
+
+	return ports
+}
@@ -0,0 +1,105 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+// procNetTcpListening is the content of /proc/net/tcp with a listening socket on port 9021
+const procNetTcpListening = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:233D 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 12345 1 0000000000000000 100 0 0 10 0
+`
+
+func TestSelectPort(t *testing.T) {
+	tests := []struct {
+		name      string
+		port      int
+		ports     []v1.ContainerPort
+		listening bool
+		reserved  map[int]bool
+		portRange *v1alpha1.PortRange
+		expected  int
+		expectErr bool
+	}{
+		{name: "free", expected: 9020},
+		{name: "declared", ports: []v1.ContainerPort{{Name: "http", ContainerPort: 9020}}, expectErr: true},
+		{name: "listening", port: 9021, listening: true, expectErr: true},
+		{name: "reserved", reserved: map[int]bool{9020: true}, expected: 9021},
+		{name: "reserved, next declared and listening", ports: []v1.ContainerPort{{Name: "http", ContainerPort: 9021}}, listening: true, reserved: map[int]bool{9020: true}, expected: 9022},
+		{name: "reserved up to the last port", port: 65534, reserved: map[int]bool{65534: true, 65535: true}, expectErr: true},
+		{name: "exposed at previous attempt", ports: []v1.ContainerPort{{Name: prometheusJmxExporterContainerPortName, ContainerPort: 9020}}, expected: 9020},
+		{name: "range", ports: []v1.ContainerPort{{Name: "http", ContainerPort: 9100}}, listening: true, portRange: &v1alpha1.PortRange{From: 9100, To: 9110}, expected: 9101},
+		{name: "range exhausted", reserved: map[int]bool{9100: true, 9101: true}, portRange: &v1alpha1.PortRange{From: 9100, To: 9101}, expectErr: true},
+	}
+
+	for _, test := range tests {
+		pod := &v1.Pod{}
+		pod.Namespace, pod.Name = "default", "app-1"
+		pod.Spec.Containers = []v1.Container{{Name: "app", Ports: test.ports}}
+
+		executor := newFakePodExecutor()
+		if test.listening {
+			executor.ProcNetTcp[fakeContainerKey("default", "app-1", "app")] = procNetTcpListening
+		}
+
+		reserved := test.reserved
+		if reserved == nil {
+			reserved = make(map[int]bool)
+		}
+
+		spec := &v1alpha1.PrometheusJmxExporterSpec{Port: 9020, PortRange: test.portRange}
+		if test.port > 0 {
+			spec.Port = test.port
+		}
+
+		port, err := selectPort(executor, pod, &pod.Spec.Containers[0], spec, reserved)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got port %d", test.name, port)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if port != test.expected {
+			t.Errorf("%s: expected port %d, got %d", test.name, test.expected, port)
+		}
+	}
+}
+
+func TestProcessQueuedPodFixedPortInUse(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	pod.Spec.Containers[0].Ports = []v1.ContainerPort{{Name: "http", ContainerPort: 9020}}
+	api.store("pods", pod)
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "app")] = "42 com.example.App\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.Inline = testConfig
+	installFakeCache(t, prometheusJmxExporter)
+
+	if err := handler.processQueuedPod("default/app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if value := stored.Annotations[prometheusJmxExporterAnnotationKey]; value != prometheusJmxExporterAnnotationVerifiedFailed {
+		t.Errorf("expected pod marked as %s, got '%s'", prometheusJmxExporterAnnotationVerifiedFailed, value)
+	}
+	if lastError := stored.Annotations[prometheusJmxExporterLastErrorAnnotationKey]; !strings.Contains(lastError, "port 9020 is already in use") {
+		t.Errorf("expected the port conflict recorded, got '%s'", lastError)
+	}
+	if len(executor.LoadedAgents) > 0 {
+		t.Errorf("expected no agent loaded, got %v", executor.LoadedAgents)
+	}
+}
@@ -0,0 +1,306 @@
+package stub
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"io"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	probeTimeout     = 5 * time.Second
+	probeConcurrency = 10
+	// probeMaxMessageLength limits the length of the health message recorded on the pod
+	probeMaxMessageLength = 256
+	// probeFailureThreshold is the number of consecutive probes an endpoint which used to be healthy has to be
+	// unreachable at before checking whether its agent is gone
+	probeFailureThreshold = 3
+)
+
+// metricLineRegexp matches a sample line of the Prometheus text exposition format: name, optional labels, value and optional timestamp
+var metricLineRegexp = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{.*\})?\s+(\S+)(\s+-?[0-9]+)?$`)
+
+// endpointHealth is the outcome of probing an endpoint, recorded on the pod by port
+type endpointHealth struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+	// Failures is the number of consecutive probes the endpoint has been unreachable at since it was healthy
+	Failures int `json:"failures,omitempty"`
+}
+
+var probeClient = &http.Client{Timeout: probeTimeout}
+
+// RunProber probes the prometheus jmx exporter endpoints of the instrumented pods every interval until stopCh is closed
+func (h *Handler) RunProber(interval time.Duration, stopCh <-chan struct{}) {
+	logrus.Infof("Probing prometheus jmx exporter endpoints every %v", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				h.probePods()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// probePods probes the endpoints of the cached instrumented pods concurrently
+func (h *Handler) probePods() {
+	semaphore := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+
+	for _, nsInformers := range informers {
+		for _, obj := range nsInformers.pods.GetStore().List() {
+			pod := obj.(*v1.Pod)
+
+			if pod.Status.Phase != v1.PodRunning || len(pod.Status.PodIP) == 0 ||
+				pod.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerified {
+				continue
+			}
+
+			wg.Add(1)
+			semaphore <- struct{}{}
+
+			go func(pod *v1.Pod) {
+				defer func() {
+					<-semaphore
+					wg.Done()
+				}()
+
+				if err := h.probePod(pod); err != nil {
+					logrus.Warnf("Probing pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+				}
+			}(pod.DeepCopy())
+		}
+	}
+
+	wg.Wait()
+}
+
+// probePod probes the endpoints of pod and records their health on the pod if it changed. If an endpoint which
+// used to be healthy has been unreachable at probeFailureThreshold consecutive probes and its agent is gone, the
+// endpoint is removed and the injection state of pod is cleared to load the agent again.
+func (h *Handler) probePod(pod *v1.Pod) error {
+	previous := parseEndpointsHealth(pod)
+	current := make(map[string]endpointHealth)
+	endpoints := createMetricEndpoints(pod)
+
+	var kept, gone []*v1alpha1.MetricsEndpoint
+	for _, endpoint := range endpoints {
+		key := strconv.Itoa(endpoint.Port)
+		health, reachable := probeEndpoint(pod.Status.PodIP, endpoint.Port)
+		if !reachable && (previous[key].Healthy || previous[key].Failures > 0) {
+			health.Failures = previous[key].Failures + 1
+		}
+
+		if health.Failures >= probeFailureThreshold && h.agentGone(pod, endpoint) {
+			gone = append(gone, endpoint)
+			continue
+		}
+
+		current[key] = health
+		kept = append(kept, endpoint)
+	}
+
+	if len(gone) > 0 {
+		logrus.Infof("Prometheus jmx exporter endpoints %s of pod '%s/%s' disappeared, reloading the agent",
+			formatEndpointsAnnotation(gone), pod.Namespace, pod.Name)
+
+		recordEvent(nil, pod, v1.EventTypeWarning, eventReasonEndpointDisappeared,
+			"Prometheus jmx exporter endpoints %s disappeared, reloading the agent", formatEndpointsAnnotation(gone))
+
+		// the endpoints of the agents still running are kept thus these are not loaded again
+		delete(pod.Annotations, prometheusJmxExporterAnnotationKey)
+		delete(pod.Annotations, prometheusJmxExporterHealthAnnotationKey)
+		if len(kept) > 0 {
+			return ignoreConflict(annotateForPrometheus(pod, kept))
+		}
+
+		delete(pod.Annotations, prometheusScrapeAnnotationKey)
+		delete(pod.Annotations, prometheusPortAnnotationKey)
+		delete(pod.Annotations, prometheusJmxExporterEndpointsAnnotationKey)
+		return ignoreConflict(annotatePod(pod, nil))
+	}
+
+	if equalEndpointsHealth(previous, current) {
+		return nil
+	}
+
+	value, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	return ignoreConflict(annotatePod(pod, map[string]string{
+		prometheusJmxExporterHealthAnnotationKey: string(value),
+	}))
+}
+
+// agentGone returns true if the agent publishing endpoint is not running anymore: its container has been restarted
+// or nothing listens on its port inside the container. Returns false if it can't be determined.
+func (h *Handler) agentGone(pod *v1.Pod, endpoint *v1alpha1.MetricsEndpoint) bool {
+	name := endpoint.Container
+	if len(name) == 0 && len(pod.Spec.Containers) > 0 {
+		// pods processed by earlier versions of the operator have only the first container instrumented
+		name = pod.Spec.Containers[0].Name
+	}
+
+	states := parseContainerStates(pod)
+	if status := findContainerStatus(pod, name); status != nil {
+		if state, ok := states[name]; ok && containerRestarted(state, status) {
+			return true
+		}
+	}
+
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name != name {
+			continue
+		}
+
+		listeningPorts, err := queryListeningPorts(h.executor, pod, container)
+		if err != nil {
+			logrus.Warnf("Checking the agent listening on port %d of '%s/%s/%s' failed: %v",
+				endpoint.Port, pod.Namespace, pod.Name, name, err)
+			return false
+		}
+
+		return !listeningPorts[endpoint.Port]
+	}
+
+	return false
+}
+
+// ignoreConflict returns nil if err is a conflict, the pod is probed again at the next round
+func ignoreConflict(err error) error {
+	if apierrors.IsConflict(err) {
+		return nil
+	}
+
+	return err
+}
+
+// probeEndpoint gets the metrics served on port of podIP and checks that these are the metrics of the JVM.
+// Returns the health of the endpoint and false if it's unreachable.
+func probeEndpoint(podIP string, port int) (endpointHealth, bool) {
+	resp, err := probeClient.Get(fmt.Sprintf("http://%s:%d%s", podIP, port, monitorMetricsPath))
+	if err != nil {
+		return unhealthy(err.Error()), false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unhealthy(fmt.Sprintf("unexpected status code %d", resp.StatusCode)), true
+	}
+
+	if err := checkMetrics(resp.Body); err != nil {
+		return unhealthy(err.Error()), true
+	}
+
+	return endpointHealth{Healthy: true}, true
+}
+
+// unhealthy returns the health of an unhealthy endpoint described by message
+func unhealthy(message string) endpointHealth {
+	if len(message) > probeMaxMessageLength {
+		message = message[:probeMaxMessageLength]
+	}
+
+	return endpointHealth{Healthy: false, Message: message}
+}
+
+// checkMetrics verifies that reader provides metrics in Prometheus text format containing the
+// jmx_scrape_error metric of the prometheus jmx exporter and jvm_ metrics
+func checkMetrics(reader io.Reader) error {
+	hasScrapeError, hasJvm := false, false
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		match := metricLineRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return fmt.Errorf("invalid metric at line %d", lineNumber)
+		}
+		if _, err := strconv.ParseFloat(match[3], 64); err != nil {
+			return fmt.Errorf("invalid value of metric '%s' at line %d", match[1], lineNumber)
+		}
+
+		hasScrapeError = hasScrapeError || match[1] == "jmx_scrape_error"
+		hasJvm = hasJvm || strings.HasPrefix(match[1], "jvm_")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if !hasScrapeError {
+		return fmt.Errorf("jmx_scrape_error metric not found")
+	}
+	if !hasJvm {
+		return fmt.Errorf("jvm_ metrics not found")
+	}
+
+	return nil
+}
+
+// parseEndpointsHealth returns the health of the endpoints of pod by port recorded at the last probe
+func parseEndpointsHealth(pod *v1.Pod) map[string]endpointHealth {
+	health := make(map[string]endpointHealth)
+
+	if value, ok := pod.Annotations[prometheusJmxExporterHealthAnnotationKey]; ok {
+		if err := json.Unmarshal([]byte(value), &health); err != nil {
+			logrus.Warnf("Decoding endpoints health of pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	return health
+}
+
+// equalEndpointsHealth returns true if health and other are the same
+func equalEndpointsHealth(health, other map[string]endpointHealth) bool {
+	if len(health) != len(other) {
+		return false
+	}
+
+	for key, value := range health {
+		if otherValue, ok := other[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setEndpointsHealth sets the health of endpoints recorded on pod
+func setEndpointsHealth(pod *v1.Pod, endpoints []*v1alpha1.MetricsEndpoint) {
+	health := parseEndpointsHealth(pod)
+
+	for _, endpoint := range endpoints {
+		if h, ok := health[strconv.Itoa(endpoint.Port)]; ok {
+			if h.Healthy {
+				endpoint.Health = v1alpha1.EndpointHealthy
+			} else {
+				endpoint.Health = v1alpha1.EndpointUnhealthy
+				endpoint.HealthMessage = h.Message
+			}
+		}
+	}
+}
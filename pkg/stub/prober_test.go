@@ -0,0 +1,122 @@
+package stub
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// newProbedPod returns a pod of api instrumented in container app with an endpoint on port
+func newProbedPod(api *fakeAPI, port int) *v1.Pod {
+	pod := newTestPod(api, "app-1", "app")
+	pod.Status.PodIP = "127.0.0.1"
+	pod.Annotations = map[string]string{
+		prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerified,
+		prometheusScrapeAnnotationKey:               "true",
+		prometheusPortAnnotationKey:                 strconv.Itoa(port),
+		prometheusJmxExporterEndpointsAnnotationKey: fmt.Sprintf("app/42:%d", port),
+	}
+	pod.Annotations[prometheusJmxExporterContainerStatesAnnotationKey] = formatContainerStates(pod)
+	api.store("pods", pod)
+
+	return pod
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbePodHealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "jmx_scrape_error 0.0")
+		fmt.Fprintln(w, "jvm_threads_current 12.0")
+	}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+
+	api := installFakeAPI()
+	handler := NewHandler(newFakePodExecutor())
+	pod := newProbedPod(api, port)
+
+	if err := handler.probePod(pod); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if health := parseEndpointsHealth(&stored)[strconv.Itoa(port)]; !health.Healthy {
+		t.Errorf("expected endpoint healthy, got %+v", health)
+	}
+}
+
+func TestProbePodReinjectsGoneAgent(t *testing.T) {
+	port := closedPort(t)
+	key := strconv.Itoa(port)
+
+	tests := []struct {
+		name      string
+		listening bool
+		restarted bool
+		reinject  bool
+	}{
+		{name: "port not listening", reinject: true},
+		{name: "port listening", listening: true},
+		{name: "container restarted", listening: true, restarted: true, reinject: true},
+	}
+
+	for _, test := range tests {
+		api := installFakeAPI()
+		executor := newFakePodExecutor()
+		handler := NewHandler(executor)
+
+		pod := newProbedPod(api, port)
+		pod.Annotations[prometheusJmxExporterHealthAnnotationKey] = fmt.Sprintf(`{"%s":{"healthy":true}}`, key)
+		if test.listening {
+			executor.ProcNetTcp[fakeContainerKey("default", "app-1", "app")] =
+				fmt.Sprintf("   0: 00000000:%04X 00000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 1 1\n", port)
+		}
+		if test.restarted {
+			pod.Status.ContainerStatuses[0].RestartCount++
+		}
+
+		for i := 1; i <= probeFailureThreshold; i++ {
+			if err := handler.probePod(pod); err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+
+			var stored v1.Pod
+			api.get(t, "pods", "default", "app-1", &stored)
+
+			reinjected := stored.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerified
+			if reinjected != (test.reinject && i == probeFailureThreshold) {
+				t.Errorf("%s: unexpected reinjection %v after %d failed probes", test.name, reinjected, i)
+			}
+			if reinjected {
+				if _, ok := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; ok {
+					t.Errorf("%s: expected the endpoint of the gone agent removed", test.name)
+				}
+				break
+			}
+
+			if health := parseEndpointsHealth(&stored)[key]; health.Healthy || health.Failures != i {
+				t.Errorf("%s: expected %d failures, got %+v", test.name, i, health)
+			}
+			pod = &stored
+		}
+	}
+}
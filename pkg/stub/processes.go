@@ -0,0 +1,141 @@
+package stub
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// javaProcess describes a java process running inside a container
+type javaProcess struct {
+	Pid string
+	// MainClass is the fully qualified name of the main class or the path of the jar file
+	MainClass string
+	// JvmArgs are the arguments passed to the JVM
+	JvmArgs []string
+	// Vendor and Version of the JVM, empty if unknown
+	Vendor  string
+	Version string
+	// User is the name or the id of the user running the process
+	User string
+}
+
+// parseJpsOutput parses the output of 'jps -lv' skipping the jps process itself. The main class of
+// the processes jps can't inspect ('<pid> -- process information unavailable') is left empty.
+func parseJpsOutput(output string) []javaProcess {
+	var javaProcs []javaProcess
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			// not a process, e.g. a warning printed by jps
+			continue
+		}
+
+		proc := javaProcess{Pid: fields[0]}
+		if len(fields) > 1 && fields[1] != "--" {
+			proc.MainClass = fields[1]
+			proc.JvmArgs = fields[2:]
+		}
+
+		if isJps(proc.MainClass) {
+			continue
+		}
+
+		javaProcs = append(javaProcs, proc)
+	}
+
+	return javaProcs
+}
+
+// isJps returns true if mainClass is the main class of jps
+func isJps(mainClass string) bool {
+	return strings.EqualFold(mainClass, "jps") || strings.HasSuffix(mainClass, "sun.tools.jps.Jps")
+}
+
+// selectJavaProcesses returns the processes from procs that match selector. If selector is not set all processes are returned.
+func selectJavaProcesses(procs []javaProcess, selector *v1alpha1.ProcessSelector) ([]javaProcess, error) {
+	if selector == nil {
+		return procs, nil
+	}
+
+	var mainClassRegex *regexp.Regexp
+	if len(selector.MainClass) > 0 {
+		var err error
+		mainClassRegex, err = regexp.Compile(selector.MainClass)
+		if err != nil {
+			return nil, fmt.Errorf("invalid main class regex '%s': %v", selector.MainClass, err)
+		}
+	}
+
+	var selected []javaProcess
+	for _, proc := range procs {
+		if mainClassRegex != nil && !mainClassRegex.MatchString(proc.MainClass) {
+			continue
+		}
+
+		if len(selector.SystemProperty) > 0 && !hasSystemProperty(proc, selector.SystemProperty) {
+			continue
+		}
+
+		selected = append(selected, proc)
+	}
+
+	return selected, nil
+}
+
+// hasSystemProperty returns true if proc has been started with the system property described by property
+// which is either in <name> or <name>=<value> format
+func hasSystemProperty(proc javaProcess, property string) bool {
+	for _, arg := range proc.JvmArgs {
+		if !strings.HasPrefix(arg, "-D") {
+			continue
+		}
+
+		if strings.Contains(property, "=") {
+			if arg[2:] == property {
+				return true
+			}
+		} else if arg[2:] == property || strings.HasPrefix(arg[2:], property+"=") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatJavaProcesses returns the pid, main class and the known JVM details of the processes delimited by comma surrounded by parenthesis.
+func formatJavaProcesses(procs []javaProcess) string {
+	var buffer bytes.Buffer
+	buffer.WriteString("(")
+	for i := 0; i < len(procs); i++ {
+		if i != 0 {
+			buffer.WriteString(",")
+		}
+		buffer.WriteString(procs[i].Pid)
+		buffer.WriteString(" ")
+		buffer.WriteString(procs[i].MainClass)
+
+		var details []string
+		for _, detail := range []string{procs[i].Vendor, procs[i].Version, procs[i].User} {
+			if len(detail) > 0 {
+				details = append(details, detail)
+			}
+		}
+		if len(details) > 0 {
+			buffer.WriteString(" [")
+			buffer.WriteString(strings.Join(details, " "))
+			buffer.WriteString("]")
+		}
+	}
+	buffer.WriteString(")")
+
+	return buffer.String()
+}
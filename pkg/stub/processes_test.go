@@ -0,0 +1,142 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"reflect"
+	"testing"
+)
+
+// testJavaProcesses are the processes of an app server forking helper JVMs
+var testJavaProcesses = []javaProcess{
+	{Pid: "42", MainClass: "org.apache.catalina.startup.Bootstrap", JvmArgs: []string{"-Dcatalina.base=/usr/local/tomcat", "-Xmx1g"}},
+	{Pid: "43", MainClass: "/opt/app/worker.jar", JvmArgs: []string{"-Dworker", "-Drole=batch"}},
+	{Pid: "44", MainClass: "/opt/app/worker.jar", JvmArgs: []string{"-Drole=stream"}},
+}
+
+func TestSelectJavaProcesses(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector *v1alpha1.ProcessSelector
+		expected []string
+	}{
+		{
+			name:     "no selector",
+			expected: []string{"42", "43", "44"},
+		},
+		{
+			name:     "main class",
+			selector: &v1alpha1.ProcessSelector{MainClass: `catalina\.startup`},
+			expected: []string{"42"},
+		},
+		{
+			name:     "jar name",
+			selector: &v1alpha1.ProcessSelector{MainClass: `worker\.jar$`},
+			expected: []string{"43", "44"},
+		},
+		{
+			name:     "system property name",
+			selector: &v1alpha1.ProcessSelector{SystemProperty: "worker"},
+			expected: []string{"43"},
+		},
+		{
+			name:     "system property name with value",
+			selector: &v1alpha1.ProcessSelector{SystemProperty: "role"},
+			expected: []string{"43", "44"},
+		},
+		{
+			name:     "system property value",
+			selector: &v1alpha1.ProcessSelector{SystemProperty: "role=stream"},
+			expected: []string{"44"},
+		},
+		{
+			name:     "main class and system property",
+			selector: &v1alpha1.ProcessSelector{MainClass: `worker\.jar$`, SystemProperty: "role=batch"},
+			expected: []string{"43"},
+		},
+		{
+			name:     "property name prefix",
+			selector: &v1alpha1.ProcessSelector{SystemProperty: "catalina"},
+		},
+		{
+			name:     "nothing matches",
+			selector: &v1alpha1.ProcessSelector{MainClass: "com.example.Missing"},
+		},
+	}
+
+	for _, test := range tests {
+		selected, err := selectJavaProcesses(testJavaProcesses, test.selector)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		var pids []string
+		for _, proc := range selected {
+			pids = append(pids, proc.Pid)
+		}
+
+		if !reflect.DeepEqual(pids, test.expected) {
+			t.Errorf("%s: expected processes %v, got %v", test.name, test.expected, pids)
+		}
+	}
+}
+
+func TestSelectJavaProcessesInvalidMainClass(t *testing.T) {
+	if _, err := selectJavaProcesses(testJavaProcesses, &v1alpha1.ProcessSelector{MainClass: "(worker"}); err == nil {
+		t.Error("expected error for invalid main class regex")
+	}
+}
+
+func TestProcessPodMultipleJavaProcesses(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	key := fakeContainerKey("default", "app-1", "app")
+	executor.JpsOutput[key] = "42 org.apache.catalina.startup.Bootstrap\n43 /opt/app/worker.jar -Drole=batch\n44 /opt/app/worker.jar -Drole=stream\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.ProcessSelector = &v1alpha1.ProcessSelector{MainClass: `worker\.jar$`}
+
+	if err := handler.processPod(pod, testConfig, prometheusJmxExporter); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// each selected process gets an agent listening on a distinct port
+	if agents := executor.LoadedAgents[key]; !reflect.DeepEqual(agents, []string{"43:9020", "44:9021"}) {
+		t.Errorf("expected agents loaded into processes 43 and 44 on ports 9020 and 9021, got %v", agents)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if endpoints := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; endpoints != "app/43:9020,app/44:9021" {
+		t.Errorf("unexpected endpoints annotation '%s'", endpoints)
+	}
+
+	endpoints := createMetricEndpoints(&stored)
+	if len(endpoints) != 2 || endpoints[0].Port == endpoints[1].Port {
+		t.Errorf("expected an endpoint published for each process, got %v", formatEndpointsAnnotation(endpoints))
+	}
+}
+
+func TestProcessPodNoJavaProcessSelected(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "app")] = "42 org.apache.catalina.startup.Bootstrap\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.ProcessSelector = &v1alpha1.ProcessSelector{SystemProperty: "role"}
+
+	if err := handler.processPod(pod, testConfig, prometheusJmxExporter); err == nil {
+		t.Fatal("expected error when no java process matches processSelector")
+	}
+	if len(executor.LoadedAgents) > 0 {
+		t.Errorf("expected no agent loaded, got %v", executor.LoadedAgents)
+	}
+}
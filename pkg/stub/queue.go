@@ -0,0 +1,156 @@
+package stub
+
+import (
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"time"
+)
+
+const (
+	podRetryBaseDelay = 5 * time.Second
+	podRetryMaxDelay  = 5 * time.Minute
+	maxPodRetries     = 8
+)
+
+// podQueue holds the keys of the pods waiting to be instrumented. A pod is in the queue at most once
+// and it's never processed by multiple workers at the same time.
+var podQueue = workqueue.NewNamedRateLimitingQueue(
+	workqueue.NewItemExponentialFailureRateLimiter(podRetryBaseDelay, podRetryMaxDelay), "pods")
+
+// RunPodWorkers starts workers goroutines which instrument the queued pods until stopCh is closed
+func (h *Handler) RunPodWorkers(workers int, stopCh <-chan struct{}) {
+	logrus.Infof("Starting %d pod workers", workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for h.processNextPod() {
+			}
+		}()
+	}
+
+	go func() {
+		<-stopCh
+		podQueue.ShutDown()
+	}()
+}
+
+// enqueuePod queues pod to load the prometheus jmx exporter agent into or to reload its config
+func enqueuePod(pod *v1.Pod) {
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		logrus.Errorf("Queueing pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+
+	podQueue.Add(key)
+}
+
+// processNextPod processes the next pod of the queue. Failing to load the agent is retried according to the
+// retry policy of the PrometheusJmxExporter, the pods failed for other reasons, like the errors of the API server,
+// are re-queued with exponential backoff. Returns false if the queue was shut down.
+func (h *Handler) processNextPod() bool {
+	item, shutdown := podQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer podQueue.Done(item)
+
+	key := item.(string)
+
+	if err := h.processQueuedPod(key); err != nil {
+		if podQueue.NumRequeues(key) < maxPodRetries {
+			logrus.Warnf("Processing pod '%s' failed, retrying: %v", key, err)
+			podQueue.AddRateLimited(key)
+			return true
+		}
+
+		logrus.Errorf("Processing pod '%s' failed, giving up after %d retries: %v", key, maxPodRetries, err)
+	}
+
+	podQueue.Forget(key)
+	return true
+}
+
+// processQueuedPod loads the prometheus jmx exporter agent into the pod identified by key if it's selected by
+// a PrometheusJmxExporter or reloads its config if it's already instrumented
+func (h *Handler) processQueuedPod(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil
+	}
+
+	// the pod is read from the API server as the cached one may not reflect the outcome of its previous processing yet
+	pod := v1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Pod",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	if err := getObject(&pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+		return nil
+	}
+
+	prometheusJmxExporters, err := queryPodPrometheusJmxExporters(&pod)
+	if err != nil {
+		return err
+	}
+
+	prometheusJmxExporter, err := findExporterForPod(prometheusJmxExporters, &pod)
+	if err != nil || prometheusJmxExporter == nil || prometheusJmxExporter.Spec.IsStartupMode() {
+		// conflicting PrometheusJmxExporters are reported on their status, retrying won't resolve them
+		return nil
+	}
+
+	config, err := loadConfig(prometheusJmxExporter)
+	if err != nil {
+		logrus.Errorf("Error during retrieving prometheus jmx exporter config")
+
+		recordEvent(prometheusJmxExporter, &pod, v1.EventTypeWarning, eventReasonConfigLoadFailed,
+			"Loading config from %s failed: %v", describeConfigSource(&prometheusJmxExporter.Spec.Config), err)
+		return err
+	}
+
+	// the agent is reloaded into the containers restarted since it was loaded, the agents of the others are kept
+	resetRestartedPod(&pod)
+
+	if isVerified(&pod) {
+		retryTime, ok := nextRetryTime(&pod)
+		if !ok {
+			return h.syncPodConfig(&pod, config)
+		}
+		if time.Now().Before(retryTime) {
+			scheduleRetry(&pod)
+			return nil
+		}
+	}
+
+	resetAttempts(&pod)
+
+	if err := h.processPod(&pod, config, prometheusJmxExporter); err != nil {
+		logrus.Warnf("Processing pod failed: %v", err)
+
+		logrus.Infof("Mark pod '%s' as verify failed", pod.Name)
+
+		// pods marked as verify failed are retried according to the retry policy, the pod is re-queued
+		// only if it couldn't be marked
+		return podVerifiedFailed(&pod, prometheusJmxExporter, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package stub
+
+import (
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// installTestPodQueue replaces podQueue with a new queue retrying the failed pods after a millisecond
+func installTestPodQueue() {
+	podQueue = workqueue.NewNamedRateLimitingQueue(
+		workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond), "test-pods")
+}
+
+func TestEnqueuePodDeduplicates(t *testing.T) {
+	installTestPodQueue()
+	defer podQueue.ShutDown()
+
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "app-1"
+
+	// a pod event and a prometheusJmxExporter event for the same pod
+	enqueuePod(pod)
+	enqueuePod(pod)
+
+	if length := podQueue.Len(); length != 1 {
+		t.Fatalf("expected pod queued once, got %d items", length)
+	}
+
+	item, _ := podQueue.Get()
+	if item != "default/app-1" {
+		t.Fatalf("unexpected item '%v'", item)
+	}
+
+	// the pod queued while it's processed is handed out again only once its processing is done
+	enqueuePod(pod)
+	if length := podQueue.Len(); length != 0 {
+		t.Errorf("expected pod not handed out while it's processed, got %d items", length)
+	}
+
+	podQueue.Done(item)
+	if length := podQueue.Len(); length != 1 {
+		t.Errorf("expected pod queued again once processed, got %d items", length)
+	}
+}
+
+func TestProcessNextPod(t *testing.T) {
+	installTestPodQueue()
+
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	key := fakeContainerKey("default", "app-1", "app")
+	executor.JpsOutput[key] = "42 com.example.App\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.Inline = testConfig
+	installFakeCache(t, prometheusJmxExporter)
+
+	enqueuePod(pod)
+
+	if !handler.processNextPod() {
+		t.Fatal("expected queue not shut down")
+	}
+
+	if agents := executor.LoadedAgents[key]; !reflect.DeepEqual(agents, []string{"42:9020"}) {
+		t.Errorf("expected agent loaded into process 42 on port 9020, got %v", agents)
+	}
+	if length := podQueue.Len(); length != 0 {
+		t.Errorf("expected processed pod removed from the queue, got %d items", length)
+	}
+	if requeues := podQueue.NumRequeues("default/app-1"); requeues != 0 {
+		t.Errorf("expected processed pod not re-queued, got %d requeues", requeues)
+	}
+
+	podQueue.ShutDown()
+	if handler.processNextPod() {
+		t.Error("expected false once the queue is shut down")
+	}
+}
+
+func TestProcessNextPodRequeuesFailedPod(t *testing.T) {
+	installTestPodQueue()
+	defer podQueue.ShutDown()
+
+	api := installFakeAPI()
+	handler := NewHandler(newFakePodExecutor())
+
+	pod := newTestPod(api, "app-1", "app")
+
+	// the configMap the config is loaded from is missing
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.ConfigMapName = "missing"
+	prometheusJmxExporter.Spec.Config.ConfigMapKey = "config.yaml"
+	installFakeCache(t, prometheusJmxExporter)
+
+	enqueuePod(pod)
+
+	for i := 1; i <= maxPodRetries; i++ {
+		handler.processNextPod()
+
+		if requeues := podQueue.NumRequeues("default/app-1"); requeues != i {
+			t.Fatalf("expected failed pod re-queued %d times, got %d", i, requeues)
+		}
+	}
+
+	// the pod re-queued after the delay is processed for the last time
+	handler.processNextPod()
+
+	if requeues := podQueue.NumRequeues("default/app-1"); requeues != 0 {
+		t.Errorf("expected pod forgotten after %d retries, got %d requeues", maxPodRetries, requeues)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if length := podQueue.Len(); length != 0 {
+		t.Errorf("expected pod given up not queued again, got %d items", length)
+	}
+}
+
+func TestRunPodWorkers(t *testing.T) {
+	installTestPodQueue()
+
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.Inline = testConfig
+	installFakeCache(t, prometheusJmxExporter)
+
+	names := []string{"app-1", "app-2", "app-3", "app-4"}
+	for _, name := range names {
+		pod := newTestPod(api, name, "app")
+		executor.JpsOutput[fakeContainerKey("default", name, "app")] = "42 com.example.App\n"
+		enqueuePod(pod)
+	}
+
+	stopCh := make(chan struct{})
+	handler.RunPodWorkers(2, stopCh)
+	defer close(stopCh)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, name := range names {
+		for {
+			var stored v1.Pod
+			api.get(t, "pods", "default", name, &stored)
+			if isVerified(&stored) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("pod '%s' not processed by the workers", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	executor.mutex.Lock()
+	defer executor.mutex.Unlock()
+
+	for _, name := range names {
+		if agents := executor.LoadedAgents[fakeContainerKey("default", name, "app")]; len(agents) != 1 {
+			t.Errorf("expected agent loaded into pod '%s' once, got %v", name, agents)
+		}
+	}
+}
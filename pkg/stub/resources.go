@@ -0,0 +1,163 @@
+package stub
+
+import (
+	"context"
+	"fmt"
+	sdkHandler "github.com/operator-framework/operator-sdk/pkg/sdk/handler"
+	sdkInformer "github.com/operator-framework/operator-sdk/pkg/sdk/informer"
+	"github.com/operator-framework/operator-sdk/pkg/util/k8sutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// The resources are read and written through dynamic clients created from the config the operator is started with
+// instead of the clients of the operator SDK which only support the in-cluster config.
+var (
+	restMapper *discovery.DeferredDiscoveryRESTMapper
+	clientPool dynamic.ClientPool
+
+	resourceInformers []sdkInformer.Informer
+)
+
+// initResourceClients sets up the rest mapper and the dynamic client pool the resources are accessed with
+func initResourceClients(client kubernetes.Interface, config *rest.Config) {
+	restMapper = discovery.NewDeferredDiscoveryRESTMapper(cached.NewMemCacheClient(client.Discovery()), meta.InterfacesForUnstructured)
+	restMapper.Reset()
+
+	dynamicConfig := *config
+	dynamicConfig.ContentConfig = dynamic.ContentConfig()
+	clientPool = dynamic.NewClientPool(&dynamicConfig, restMapper, dynamic.LegacyAPIPathResolverFunc)
+}
+
+// resourceClient returns the dynamic client and the plural name of the resource identified by apiVersion and kind in namespace
+func resourceClient(apiVersion, kind, namespace string) (dynamic.ResourceInterface, string, error) {
+	if clientPool == nil {
+		return nil, "", fmt.Errorf("kubernetes clients are not initialized")
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid apiVersion '%s': %v", apiVersion, err)
+	}
+	gvk := gv.WithKind(kind)
+
+	client, err := clientPool.ClientForGroupVersionKind(gvk)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating client for %s failed: %v", gvk.String(), err)
+	}
+
+	mapping, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, "", fmt.Errorf("mapping %s to resource failed: %v", gvk.String(), err)
+	}
+
+	resource := &metav1.APIResource{
+		Name:       mapping.Resource,
+		Namespaced: mapping.Scope == meta.RESTScopeNamespace,
+		Kind:       kind,
+	}
+
+	return client.Resource(resource, namespace), mapping.Resource, nil
+}
+
+// resourceClientFor returns the dynamic client of the resource of object, which must have its kind, apiVersion,
+// name and namespace set, and the name of object
+func resourceClientFor(object runtime.Object) (dynamic.ResourceInterface, string, error) {
+	name, namespace, err := k8sutil.GetNameAndNamespace(object)
+	if err != nil {
+		return nil, "", err
+	}
+
+	gvk := object.GetObjectKind().GroupVersionKind()
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	client, _, err := resourceClient(apiVersion, kind, namespace)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, name, nil
+}
+
+// getObject reads object from the API server into object
+func getObject(object runtime.Object) error {
+	client, name, err := resourceClientFor(object)
+	if err != nil {
+		return err
+	}
+
+	u, err := client.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	return k8sutil.UnstructuredIntoRuntimeObject(u, object)
+}
+
+// createObject creates object and updates it with the object returned by the API server
+func createObject(object runtime.Object) error {
+	client, _, err := resourceClientFor(object)
+	if err != nil {
+		return err
+	}
+
+	u, err := client.Create(k8sutil.UnstructuredFromRuntimeObject(object))
+	if err != nil {
+		return err
+	}
+
+	return k8sutil.UnstructuredIntoRuntimeObject(u, object)
+}
+
+// updateObject updates object and updates it with the object returned by the API server
+func updateObject(object runtime.Object) error {
+	client, _, err := resourceClientFor(object)
+	if err != nil {
+		return err
+	}
+
+	u, err := client.Update(k8sutil.UnstructuredFromRuntimeObject(object))
+	if err != nil {
+		return err
+	}
+
+	return k8sutil.UnstructuredIntoRuntimeObject(u, object)
+}
+
+// deleteObject deletes object
+func deleteObject(object runtime.Object) error {
+	client, name, err := resourceClientFor(object)
+	if err != nil {
+		return err
+	}
+
+	return client.Delete(name, &metav1.DeleteOptions{})
+}
+
+// WatchResource registers an informer sending the events of the resources identified by apiVersion and kind in
+// namespace to the handler passed to Run. resyncPeriod is the period of resending the events in seconds, 0 disables it.
+func WatchResource(apiVersion, kind, namespace string, resyncPeriod int) error {
+	client, pluralName, err := resourceClient(apiVersion, kind, namespace)
+	if err != nil {
+		return err
+	}
+
+	resourceInformers = append(resourceInformers, sdkInformer.New(pluralName, namespace, client, resyncPeriod))
+	return nil
+}
+
+// Run sends the events of the resources registered by WatchResource to handler until ctx is done
+func Run(ctx context.Context, handler sdkHandler.Handler) {
+	sdkHandler.RegisteredHandler = handler
+
+	for _, informer := range resourceInformers {
+		go informer.Run(ctx)
+	}
+	<-ctx.Done()
+}
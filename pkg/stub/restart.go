@@ -0,0 +1,137 @@
+package stub
+
+import (
+	"encoding/json"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+// containerState identifies a run of a container, it changes when kubelet restarts the container
+type containerState struct {
+	RestartCount int32  `json:"restartCount"`
+	ContainerID  string `json:"containerID,omitempty"`
+}
+
+// formatContainerStates returns the value of the container states annotation of pod: the states of the
+// instrumented containers by container name in JSON format. Returns empty string if there are none.
+func formatContainerStates(pod *v1.Pod) string {
+	states := make(map[string]containerState)
+
+	for _, container := range instrumentedContainers(pod) {
+		if status := findContainerStatus(pod, container.Name); status != nil {
+			states[container.Name] = containerState{
+				RestartCount: status.RestartCount,
+				ContainerID:  status.ContainerID,
+			}
+		}
+	}
+
+	if len(states) == 0 {
+		return ""
+	}
+
+	value, err := json.Marshal(states)
+	if err != nil {
+		logrus.Errorf("Encoding container states of pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+		return ""
+	}
+
+	return string(value)
+}
+
+// findContainerStatus returns the status of the container of pod named name, nil if it's not found
+func findContainerStatus(pod *v1.Pod, name string) *v1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+
+	return nil
+}
+
+// restartedContainers returns the names of the instrumented containers of pod that have been restarted since the
+// prometheus jmx exporter agent was loaded into them and are running again. The new java processes of these containers
+// don't have the agent loaded and the files copied into the container are lost.
+func restartedContainers(pod *v1.Pod) []string {
+	if pod.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerified {
+		return nil
+	}
+
+	var restarted []string
+	for name, state := range parseContainerStates(pod) {
+		status := findContainerStatus(pod, name)
+		if status == nil || status.State.Running == nil {
+			// the agent can be loaded only once the container runs again
+			continue
+		}
+
+		if containerRestarted(state, status) {
+			restarted = append(restarted, name)
+		}
+	}
+
+	return restarted
+}
+
+// attachedEndpoints returns the endpoints of pod whose agents have been loaded at a previous attempt into
+// containers which haven't been restarted since, thus the agents are still running
+func attachedEndpoints(pod *v1.Pod) []*v1alpha1.MetricsEndpoint {
+	states := parseContainerStates(pod)
+
+	var endpoints []*v1alpha1.MetricsEndpoint
+	for _, endpoint := range createMetricEndpoints(pod) {
+		state, ok := states[endpoint.Container]
+		if !ok {
+			continue
+		}
+
+		if status := findContainerStatus(pod, endpoint.Container); status != nil && !containerRestarted(state, status) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints
+}
+
+// parseContainerStates returns the states of the instrumented containers of pod recorded in the container states annotation
+func parseContainerStates(pod *v1.Pod) map[string]containerState {
+	value, ok := pod.Annotations[prometheusJmxExporterContainerStatesAnnotationKey]
+	if !ok {
+		return nil
+	}
+
+	var states map[string]containerState
+	if err := json.Unmarshal([]byte(value), &states); err != nil {
+		logrus.Warnf("Decoding container states of pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+
+	return states
+}
+
+// containerRestarted returns true if the container with status has been restarted since it was in state
+func containerRestarted(state containerState, status *v1.ContainerStatus) bool {
+	return status.RestartCount != state.RestartCount ||
+		(len(state.ContainerID) > 0 && len(status.ContainerID) > 0 && status.ContainerID != state.ContainerID)
+}
+
+// resetRestartedPod clears the injection state of pod if any of its instrumented containers has been restarted
+// thus the pod is processed again. The agents of the containers which haven't been restarted are kept, these are
+// told apart by the container states annotation. Returns true if the state was cleared.
+func resetRestartedPod(pod *v1.Pod) bool {
+	restarted := restartedContainers(pod)
+	if len(restarted) == 0 {
+		return false
+	}
+
+	logrus.Infof("Containers %v of pod '%s/%s' restarted, reloading prometheus jmx exporter agent", restarted, pod.Namespace, pod.Name)
+
+	recordEvent(nil, pod, v1.EventTypeNormal, eventReasonContainerRestarted,
+		"Containers %v restarted, reloading prometheus jmx exporter agent", restarted)
+
+	delete(pod.Annotations, prometheusJmxExporterAnnotationKey)
+
+	return true
+}
@@ -0,0 +1,203 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newRestartTestPod returns a pod verified with the agents loaded into its app and sidecar containers at restart count 0
+func newRestartTestPod() *v1.Pod {
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "app-1"
+	pod.Annotations = map[string]string{
+		prometheusJmxExporterAnnotationKey:                prometheusJmxExporterAnnotationVerified,
+		prometheusScrapeAnnotationKey:                     "true",
+		prometheusJmxExporterEndpointsAnnotationKey:       "app/42:9020,sidecar/7:9021",
+		prometheusJmxExporterContainerStatesAnnotationKey: `{"app":{"restartCount":0,"containerID":"docker://app"},"sidecar":{"restartCount":0,"containerID":"docker://sidecar"}}`,
+	}
+
+	for _, name := range []string{"app", "sidecar"} {
+		pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: name})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+			Name:        name,
+			ContainerID: "docker://" + name,
+			State:       v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		})
+	}
+
+	return pod
+}
+
+func TestRestartedContainers(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(pod *v1.Pod)
+		expected []string
+	}{
+		{
+			name:   "unchanged",
+			update: func(pod *v1.Pod) {},
+		},
+		{
+			name: "restart count changed",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[0].RestartCount = 1
+			},
+			expected: []string{"app"},
+		},
+		{
+			name: "container id changed",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[1].ContainerID = "docker://sidecar-2"
+			},
+			expected: []string{"sidecar"},
+		},
+		{
+			name: "both restarted",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[0].RestartCount = 2
+				pod.Status.ContainerStatuses[1].RestartCount = 1
+			},
+			expected: []string{"app", "sidecar"},
+		},
+		{
+			name: "restarted but not running yet",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[0].RestartCount = 1
+				pod.Status.ContainerStatuses[0].State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+			},
+		},
+		{
+			name: "not verified",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[0].RestartCount = 1
+				pod.Annotations[prometheusJmxExporterAnnotationKey] = prometheusJmxExporterAnnotationVerifiedFailed
+			},
+		},
+		{
+			name: "no container states",
+			update: func(pod *v1.Pod) {
+				pod.Status.ContainerStatuses[0].RestartCount = 1
+				delete(pod.Annotations, prometheusJmxExporterContainerStatesAnnotationKey)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		pod := newRestartTestPod()
+		test.update(pod)
+
+		restarted := restartedContainers(pod)
+		sort.Strings(restarted)
+
+		if len(restarted) != len(test.expected) || (len(restarted) > 0 && !reflect.DeepEqual(restarted, test.expected)) {
+			t.Errorf("%s: expected restarted containers %v, got %v", test.name, test.expected, restarted)
+		}
+	}
+}
+
+func TestAttachedEndpoints(t *testing.T) {
+	pod := newRestartTestPod()
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+
+	endpoints := attachedEndpoints(pod)
+	if len(endpoints) != 1 || endpoints[0].Container != "sidecar" || endpoints[0].Port != 9021 {
+		t.Errorf("expected only the endpoint of sidecar attached, got %v", formatEndpointsAnnotation(endpoints))
+	}
+
+	// an endpoint without recorded container state may belong to a previous run of its container
+	delete(pod.Annotations, prometheusJmxExporterContainerStatesAnnotationKey)
+	if endpoints := attachedEndpoints(pod); len(endpoints) > 0 {
+		t.Errorf("expected no endpoint attached without container states, got %v", formatEndpointsAnnotation(endpoints))
+	}
+}
+
+func TestResetRestartedPod(t *testing.T) {
+	pod := newRestartTestPod()
+	if resetRestartedPod(pod) {
+		t.Error("expected pod without restarted containers not reset")
+	}
+	if !isVerified(pod) {
+		t.Error("expected pod without restarted containers kept verified")
+	}
+
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	if !resetRestartedPod(pod) {
+		t.Fatal("expected pod with restarted container reset")
+	}
+	if isVerified(pod) {
+		t.Error("expected restarted pod processed again")
+	}
+	if _, ok := pod.Annotations[prometheusJmxExporterContainerStatesAnnotationKey]; !ok {
+		t.Error("expected the container states kept to tell the agents still running apart")
+	}
+}
+
+func TestProcessQueuedPodReloadsRestartedContainers(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app", "sidecar")
+	for i := range pod.Status.ContainerStatuses {
+		pod.Status.ContainerStatuses[i].State = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	}
+	api.store("pods", pod)
+
+	appKey := fakeContainerKey("default", "app-1", "app")
+	sidecarKey := fakeContainerKey("default", "app-1", "sidecar")
+	executor.JpsOutput[appKey] = "42 com.example.App\n"
+	executor.JpsOutput[sidecarKey] = "7 com.example.Sidecar\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.Inline = testConfig
+	prometheusJmxExporter.Spec.ContainerSelector = &v1alpha1.ContainerSelector{AllJavaContainers: true}
+	installFakeCache(t, prometheusJmxExporter)
+
+	if err := handler.processQueuedPod("default/app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// processing the unchanged pod again doesn't reload the agents
+	if err := handler.processQueuedPod("default/app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if agents := executor.LoadedAgents[appKey]; len(agents) != 1 {
+		t.Fatalf("expected agent loaded into app once, got %v", agents)
+	}
+
+	var stored v1.Pod
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	// kubelet restarts the app container, the new java process runs without the agent
+	stored.Status.ContainerStatuses[0].RestartCount = 1
+	stored.Status.ContainerStatuses[0].ContainerID = "docker://app-2"
+	api.store("pods", &stored)
+	executor.JpsOutput[appKey] = "43 com.example.App\n"
+
+	if err := handler.processQueuedPod("default/app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if agents := executor.LoadedAgents[appKey]; !reflect.DeepEqual(agents, []string{"42:9020", "43:9020"}) {
+		t.Errorf("expected agent loaded into the restarted app again, got %v", agents)
+	}
+	if agents := executor.LoadedAgents[sidecarKey]; !reflect.DeepEqual(agents, []string{"7:9021"}) {
+		t.Errorf("expected agent of sidecar kept, got %v", agents)
+	}
+
+	api.get(t, "pods", "default", "app-1", &stored)
+
+	if !isVerified(&stored) {
+		t.Errorf("expected restarted pod verified again, got '%s'", stored.Annotations[prometheusJmxExporterAnnotationKey])
+	}
+	if endpoints := stored.Annotations[prometheusJmxExporterEndpointsAnnotationKey]; endpoints != "sidecar/7:9021,app/43:9020" {
+		t.Errorf("unexpected endpoints annotation '%s'", endpoints)
+	}
+	if restarted := restartedContainers(&stored); len(restarted) > 0 {
+		t.Errorf("expected the new container states recorded, got restarted containers %v", restarted)
+	}
+}
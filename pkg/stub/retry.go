@@ -0,0 +1,156 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBackoff     = 30 * time.Second
+	defaultRetryMaxBackoff  = 10 * time.Minute
+)
+
+// validateRetryPolicy checks the fields of retryPolicy
+func validateRetryPolicy(retryPolicy *v1alpha1.RetryPolicy) []string {
+	var problems []string
+
+	if retryPolicy.MaxAttempts < 0 {
+		problems = append(problems, fmt.Sprintf("retryPolicy.maxAttempts %d must not be negative", retryPolicy.MaxAttempts))
+	}
+	if len(retryPolicy.Backoff) > 0 {
+		if d, err := time.ParseDuration(retryPolicy.Backoff); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("retryPolicy.backoff '%s' is not a positive duration", retryPolicy.Backoff))
+		}
+	}
+	if len(retryPolicy.MaxBackoff) > 0 {
+		if d, err := time.ParseDuration(retryPolicy.MaxBackoff); err != nil || d <= 0 {
+			problems = append(problems, fmt.Sprintf("retryPolicy.maxBackoff '%s' is not a positive duration", retryPolicy.MaxBackoff))
+		}
+	}
+
+	return problems
+}
+
+// maxAttempts returns the number of attempts to load the agent into a pod, 1 if retryPolicy is not set
+func maxAttempts(retryPolicy *v1alpha1.RetryPolicy) int {
+	if retryPolicy == nil {
+		return 1
+	}
+	if retryPolicy.MaxAttempts == 0 {
+		return defaultRetryMaxAttempts
+	}
+
+	return retryPolicy.MaxAttempts
+}
+
+// retryBackoff returns the delay before the next attempt after attempts failed ones
+func retryBackoff(retryPolicy *v1alpha1.RetryPolicy, attempts int) time.Duration {
+	backoff, err := time.ParseDuration(retryPolicy.Backoff)
+	if err != nil || backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff, err := time.ParseDuration(retryPolicy.MaxBackoff)
+	if err != nil || maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
+
+// failedAttempts returns the number of failed attempts to load the agent into pod
+func failedAttempts(pod *v1.Pod) int {
+	attempts, err := strconv.Atoi(pod.Annotations[prometheusJmxExporterAttemptsAnnotationKey])
+	if err != nil {
+		return 0
+	}
+
+	return attempts
+}
+
+// nextRetryTime returns the time of the next attempt to load the agent into pod, false if no retry is scheduled
+func nextRetryTime(pod *v1.Pod) (time.Time, bool) {
+	if pod.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerifiedFailed {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, pod.Annotations[prometheusJmxExporterNextRetryAnnotationKey])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// resetAttempts forgets the failed attempts of pod if it's not marked as verify failed, that is it's processed for
+// the first time or the user forced a retry by removing the jmx-prometheus-exporter annotation
+func resetAttempts(pod *v1.Pod) {
+	if pod.Annotations[prometheusJmxExporterAnnotationKey] != prometheusJmxExporterAnnotationVerifiedFailed {
+		delete(pod.Annotations, prometheusJmxExporterAttemptsAnnotationKey)
+		delete(pod.Annotations, prometheusJmxExporterNextRetryAnnotationKey)
+	}
+}
+
+// scheduleRetry queues pod to be processed again when its next attempt is due
+func scheduleRetry(pod *v1.Pod) {
+	retryTime, ok := nextRetryTime(pod)
+	if !ok {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		logrus.Errorf("Scheduling retry of pod '%s/%s' failed: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+
+	podQueue.AddAfter(key, time.Until(retryTime))
+}
+
+// podVerifiedFailed updates the pod annotations to mark it as verify failed by cause and counts the failed attempt.
+// If the retry policy of prometheusJmxExporter allows another attempt its time is recorded on the pod and
+// the pod is queued to be retried then, otherwise the pod is given up.
+func podVerifiedFailed(pod *v1.Pod, prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, cause error) error {
+	retryPolicy := prometheusJmxExporter.Spec.RetryPolicy
+	attempts := failedAttempts(pod) + 1
+
+	annotations := map[string]string{
+		prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerifiedFailed,
+		prometheusJmxExporterAttemptsAnnotationKey:  strconv.Itoa(attempts),
+		prometheusJmxExporterLastErrorAnnotationKey: cause.Error(),
+	}
+
+	if attempts < maxAttempts(retryPolicy) {
+		retryTime := time.Now().Add(retryBackoff(retryPolicy, attempts)).UTC()
+		annotations[prometheusJmxExporterNextRetryAnnotationKey] = retryTime.Format(time.RFC3339)
+
+		logrus.Infof("Retrying pod '%s/%s' at %s", pod.Namespace, pod.Name, annotations[prometheusJmxExporterNextRetryAnnotationKey])
+	} else {
+		delete(pod.Annotations, prometheusJmxExporterNextRetryAnnotationKey)
+
+		if retryPolicy != nil {
+			recordEvent(prometheusJmxExporter, pod, v1.EventTypeWarning, eventReasonRetriesExhausted,
+				"Giving up loading the prometheus jmx exporter agent after %d attempts", attempts)
+		}
+	}
+
+	if err := annotatePod(pod, annotations); err != nil {
+		return err
+	}
+
+	scheduleRetry(pod)
+
+	return nil
+}
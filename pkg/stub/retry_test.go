@@ -0,0 +1,140 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessQueuedPodRetryPolicy(t *testing.T) {
+	key := fakeContainerKey("default", "app-1", "app")
+
+	tests := []struct {
+		name        string
+		retryPolicy *v1alpha1.RetryPolicy
+		annotations map[string]string
+		jps         string
+		errors      map[string]error
+		attempts    string
+		retry       bool
+		lastError   string
+	}{
+		{
+			name:      "no java process",
+			jps:       "",
+			attempts:  "1",
+			lastError: "no java process found",
+		},
+		{
+			name:        "attach failed",
+			retryPolicy: &v1alpha1.RetryPolicy{MaxAttempts: 3},
+			jps:         "42 com.example.App\n",
+			errors:      map[string]error{"java": fmt.Errorf("command terminated with exit code 1")},
+			attempts:    "1",
+			retry:       true,
+			lastError:   "exit code 1",
+		},
+		{
+			name:        "multiple processes, retries exhausted",
+			retryPolicy: &v1alpha1.RetryPolicy{MaxAttempts: 3},
+			annotations: map[string]string{
+				prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerifiedFailed,
+				prometheusJmxExporterAttemptsAnnotationKey:  "2",
+				prometheusJmxExporterNextRetryAnnotationKey: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+			},
+			jps:       "42 com.example.App\n43 com.example.Worker\n",
+			attempts:  "3",
+			lastError: "multiple java processes found",
+		},
+	}
+
+	for _, test := range tests {
+		api := installFakeAPI()
+		executor := newFakePodExecutor()
+		handler := NewHandler(executor)
+
+		pod := newTestPod(api, "app-1", "app")
+		pod.Annotations = test.annotations
+		api.store("pods", pod)
+
+		executor.JpsOutput[key] = test.jps
+		for program, err := range test.errors {
+			executor.Errors[program] = err
+		}
+
+		prometheusJmxExporter := newTestPrometheusJmxExporter()
+		prometheusJmxExporter.Spec.Config.Inline = testConfig
+		prometheusJmxExporter.Spec.RetryPolicy = test.retryPolicy
+		installFakeCache(t, prometheusJmxExporter)
+
+		// injection failures are handled by the retry policy, the pod is not re-queued
+		if err := handler.processQueuedPod("default/app-1"); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		var stored v1.Pod
+		api.get(t, "pods", "default", "app-1", &stored)
+
+		if value := stored.Annotations[prometheusJmxExporterAnnotationKey]; value != prometheusJmxExporterAnnotationVerifiedFailed {
+			t.Errorf("%s: expected pod marked as %s, got '%s'", test.name, prometheusJmxExporterAnnotationVerifiedFailed, value)
+		}
+		if attempts := stored.Annotations[prometheusJmxExporterAttemptsAnnotationKey]; attempts != test.attempts {
+			t.Errorf("%s: expected %s attempts, got '%s'", test.name, test.attempts, attempts)
+		}
+		if _, retry := nextRetryTime(&stored); retry != test.retry {
+			t.Errorf("%s: expected retry scheduled %v, got %v", test.name, test.retry, retry)
+		}
+		if lastError := stored.Annotations[prometheusJmxExporterLastErrorAnnotationKey]; !strings.Contains(lastError, test.lastError) {
+			t.Errorf("%s: expected last error containing '%s', got '%s'", test.name, test.lastError, lastError)
+		}
+		if len(executor.LoadedAgents) > 0 {
+			t.Errorf("%s: expected no agent loaded, got %v", test.name, executor.LoadedAgents)
+		}
+	}
+}
+
+func TestProcessQueuedPodWaitsForRetry(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	pod.Annotations = map[string]string{
+		prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerifiedFailed,
+		prometheusJmxExporterAttemptsAnnotationKey:  "1",
+		prometheusJmxExporterNextRetryAnnotationKey: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}
+	api.store("pods", pod)
+	executor.JpsOutput[fakeContainerKey("default", "app-1", "app")] = "42 com.example.App\n"
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	prometheusJmxExporter.Spec.Config.Inline = testConfig
+	prometheusJmxExporter.Spec.RetryPolicy = &v1alpha1.RetryPolicy{MaxAttempts: 3}
+	installFakeCache(t, prometheusJmxExporter)
+
+	if err := handler.processQueuedPod("default/app-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(executor.Commands) > 0 {
+		t.Errorf("expected nothing executed before the retry is due, got %v", executor.Commands)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	retryPolicy := &v1alpha1.RetryPolicy{Backoff: "10s", MaxBackoff: "1m"}
+
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, backoff := range expected {
+		if actual := retryBackoff(retryPolicy, i+1); actual != backoff {
+			t.Errorf("attempt %d: expected backoff %s, got %s", i+1, backoff, actual)
+		}
+	}
+
+	if actual := retryBackoff(&v1alpha1.RetryPolicy{}, 1); actual != defaultRetryBackoff {
+		t.Errorf("expected default backoff %s, got %s", defaultRetryBackoff, actual)
+	}
+}
@@ -0,0 +1,74 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+)
+
+// configSecretName returns the name of the secret holding the prometheus jmx exporter config of prometheusJmxExporter
+func configSecretName(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) string {
+	return prometheusJmxExporter.Name + "-jmx-exporter-config"
+}
+
+// newConfigSecret returns the secret holding config on behalf of prometheusJmxExporter which the init container
+// injected in startup mode copies the config from. The config may hold the credentials of the JMX connection thus
+// it's kept in a secret instead of the spec of the pods. The secret is owned by prometheusJmxExporter.
+func newConfigSecret(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, config *v1alpha1.PrometheusJmxExporterConfig) (*v1.Secret, error) {
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            configSecretName(prometheusJmxExporter),
+			Namespace:       prometheusJmxExporter.Namespace,
+			Labels:          map[string]string{monitorLabelKey: prometheusJmxExporter.Name},
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(prometheusJmxExporter)},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			prometheusJmxExportedConfigFilename: configData,
+		},
+	}, nil
+}
+
+// applyConfigSecret creates secret or updates its labels and data if it already exists and differs
+func applyConfigSecret(secret *v1.Secret) error {
+	existing := &v1.Secret{
+		TypeMeta:   secret.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: secret.Namespace},
+	}
+
+	err := getObject(existing)
+	if apierrors.IsNotFound(err) {
+		logrus.Infof("Creating secret '%s/%s'", secret.Namespace, secret.Name)
+		return createObject(secret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(existing.Data, secret.Data) &&
+		reflect.DeepEqual(existing.Labels, secret.Labels) &&
+		reflect.DeepEqual(existing.OwnerReferences, secret.OwnerReferences) {
+		return nil
+	}
+
+	logrus.Infof("Updating secret '%s/%s'", secret.Namespace, secret.Name)
+
+	existing.Labels = secret.Labels
+	existing.OwnerReferences = secret.OwnerReferences
+	existing.Data = secret.Data
+
+	return updateObject(existing)
+}
@@ -0,0 +1,114 @@
+package stub
+
+import (
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// podSelector returns the selector of the pods of prometheusJmxExporter
+func podSelector(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) (labels.Selector, error) {
+	return metav1.LabelSelectorAsSelector(prometheusJmxExporter.Spec.PodSelector())
+}
+
+// selectsPod returns true if the selector of prometheusJmxExporter matches the labels of pod.
+// Invalid selectors don't match any pod.
+func selectsPod(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, pod *v1.Pod) bool {
+	selector, err := podSelector(prometheusJmxExporter)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(pod.Labels))
+}
+
+// queryPrometheusJmxExporterPods returns the pods selected by prometheusJmxExporter
+func queryPrometheusJmxExporterPods(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) (*v1.PodList, error) {
+	return queryPods(prometheusJmxExporter.Namespace, prometheusJmxExporter.Spec.PodSelector())
+}
+
+// isEmptySelector returns true if selector matches all pods
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// selectorsOverlap returns true if there can be a set of labels matched by both selectors, that is
+// none of the requirements of selector contradicts a requirement of other on the same label
+func selectorsOverlap(selector, other *metav1.LabelSelector) bool {
+	for _, requirement := range selectorRequirements(selector) {
+		for _, otherRequirement := range selectorRequirements(other) {
+			if requirement.Key == otherRequirement.Key && requirementsContradict(requirement, otherRequirement) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// selectorRequirements returns the requirements of selector with matchLabels converted to In requirements
+func selectorRequirements(selector *metav1.LabelSelector) []metav1.LabelSelectorRequirement {
+	var requirements []metav1.LabelSelectorRequirement
+
+	for key, value := range selector.MatchLabels {
+		requirements = append(requirements, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: metav1.LabelSelectorOpIn,
+			Values:   []string{value},
+		})
+	}
+
+	return append(requirements, selector.MatchExpressions...)
+}
+
+// requirementsContradict returns true if no value of a label satisfies both requirement and other
+func requirementsContradict(requirement, other metav1.LabelSelectorRequirement) bool {
+	switch {
+	case requirement.Operator == metav1.LabelSelectorOpIn && other.Operator == metav1.LabelSelectorOpIn:
+		return !intersects(requirement.Values, other.Values)
+	case requirement.Operator == metav1.LabelSelectorOpIn && other.Operator == metav1.LabelSelectorOpNotIn:
+		return isSubset(requirement.Values, other.Values)
+	case requirement.Operator == metav1.LabelSelectorOpNotIn && other.Operator == metav1.LabelSelectorOpIn:
+		return isSubset(other.Values, requirement.Values)
+	case requirement.Operator == metav1.LabelSelectorOpDoesNotExist:
+		return other.Operator == metav1.LabelSelectorOpIn || other.Operator == metav1.LabelSelectorOpExists
+	case other.Operator == metav1.LabelSelectorOpDoesNotExist:
+		return requirement.Operator == metav1.LabelSelectorOpIn || requirement.Operator == metav1.LabelSelectorOpExists
+	}
+
+	return false
+}
+
+// intersects returns true if values and other have a common element
+func intersects(values, other []string) bool {
+	for _, value := range values {
+		if containsString(other, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSubset returns true if all elements of values are in other
+func isSubset(values, other []string) bool {
+	for _, value := range values {
+		if !containsString(other, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsString returns true if values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
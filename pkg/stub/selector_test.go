@@ -0,0 +1,58 @@
+package stub
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestSelectorsOverlap(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector metav1.LabelSelector
+		other    metav1.LabelSelector
+		overlap  bool
+	}{
+		{
+			name:     "different keys",
+			selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+			other:    metav1.LabelSelector{MatchLabels: map[string]string{"team": "x"}},
+			overlap:  true,
+		},
+		{
+			name:     "different values",
+			selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+			other:    metav1.LabelSelector{MatchLabels: map[string]string{"app": "b", "team": "x"}},
+		},
+		{
+			name:     "not in",
+			selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+			other: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"a", "b"}},
+			}},
+		},
+		{
+			name:     "does not exist",
+			selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+			other: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpDoesNotExist},
+			}},
+		},
+		{
+			name:     "in",
+			selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}},
+			other: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"a", "b"}},
+			}},
+			overlap: true,
+		},
+	}
+
+	for _, test := range tests {
+		if overlap := selectorsOverlap(&test.selector, &test.other); overlap != test.overlap {
+			t.Errorf("%s: expected overlap %v, got %v", test.name, test.overlap, overlap)
+		}
+		if overlap := selectorsOverlap(&test.other, &test.selector); overlap != test.overlap {
+			t.Errorf("%s: expected overlap %v in reverse, got %v", test.name, test.overlap, overlap)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// newPrometheusJmxExporterStatus returns an empty status for generation of an exporter which keeps
+// the conditions of the current status in order to preserve their transition times
+func newPrometheusJmxExporterStatus(current v1alpha1.PrometheusJmxExporterStatus, generation int64) v1alpha1.PrometheusJmxExporterStatus {
+	status := v1alpha1.PrometheusJmxExporterStatus{
+		ObservedGeneration: generation,
+	}
+
+	for _, condition := range current.Conditions {
+		status.Conditions = append(status.Conditions, *condition.DeepCopy())
+	}
+
+	return status
+}
+
+// updatePrometheusJmxExporterStatus stores status on prometheusJmxExporter if it differs from the current one
+func updatePrometheusJmxExporterStatus(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter, status v1alpha1.PrometheusJmxExporterStatus) error {
+	if prometheusJmxExporter.Status.Equals(status) {
+		return nil
+	}
+
+	prometheusJmxExporter.Status = status
+
+	logrus.Infof(
+		"PrometheusJmxExporter: '%s/%s' : Update status",
+		prometheusJmxExporter.Namespace,
+		prometheusJmxExporter.Name)
+
+	return updateObject(prometheusJmxExporter)
+}
+
+// setConfigValidCondition sets the ConfigValid condition according to the error of loading the config
+func setConfigValidCondition(status *v1alpha1.PrometheusJmxExporterStatus, err error) {
+	if err != nil {
+		status.SetCondition(v1alpha1.ConditionConfigValid, v1.ConditionFalse, "ConfigLoadFailed", err.Error())
+	} else {
+		status.SetCondition(v1alpha1.ConditionConfigValid, v1.ConditionTrue, "ConfigLoaded", "")
+	}
+}
+
+// setConflictCondition sets the Conflict condition according to the error of the conflict check. The names of the
+// exporters whose selectors may select the same pods without a conflict yet are reported as a warning.
+func setConflictCondition(status *v1alpha1.PrometheusJmxExporterStatus, err error, overlapping []string) {
+	switch {
+	case err != nil:
+		status.SetCondition(v1alpha1.ConditionConflict, v1.ConditionTrue, "SelectorConflict", err.Error())
+	case len(overlapping) > 0:
+		status.SetCondition(v1alpha1.ConditionConflict, v1.ConditionFalse, "SelectorsOverlap",
+			fmt.Sprintf("selector may select the same pods as the selectors of prometheusjmxexporters '%s'", strings.Join(overlapping, "', '")))
+	default:
+		status.SetCondition(v1alpha1.ConditionConflict, v1.ConditionFalse, "NoConflict", "")
+	}
+}
+
+// updateReadyConditions computes the Degraded and Ready conditions from the other conditions
+// and the state of the pods
+func updateReadyConditions(status *v1alpha1.PrometheusJmxExporterStatus) {
+	var failed, pending int
+	for _, podStatus := range status.Pods {
+		switch podStatus.Phase {
+		case v1alpha1.PodFailed:
+			failed++
+		case v1alpha1.PodPending:
+			pending++
+		}
+	}
+
+	var unhealthy int
+	for _, endpoint := range status.MetricsEndpoints {
+		if endpoint.Health == v1alpha1.EndpointUnhealthy {
+			unhealthy++
+		}
+	}
+
+	if failed > 0 {
+		status.SetCondition(v1alpha1.ConditionDegraded, v1.ConditionTrue, "PodsFailed",
+			fmt.Sprintf("loading the agent failed in %d of %d pods", failed, len(status.Pods)))
+	} else if unhealthy > 0 {
+		status.SetCondition(v1alpha1.ConditionDegraded, v1.ConditionTrue, "EndpointsUnhealthy",
+			fmt.Sprintf("%d of %d endpoints are unhealthy", unhealthy, len(status.MetricsEndpoints)))
+	} else {
+		status.SetCondition(v1alpha1.ConditionDegraded, v1.ConditionFalse, "NoPodsFailed", "")
+	}
+
+	if c := status.GetCondition(v1alpha1.ConditionConfigValid); c != nil && c.Status == v1.ConditionFalse {
+		status.SetCondition(v1alpha1.ConditionReady, v1.ConditionFalse, "ConfigInvalid", c.Message)
+	} else if c := status.GetCondition(v1alpha1.ConditionConflict); c != nil && c.Status == v1.ConditionTrue {
+		status.SetCondition(v1alpha1.ConditionReady, v1.ConditionFalse, "Conflict", c.Message)
+	} else if failed > 0 {
+		status.SetCondition(v1alpha1.ConditionReady, v1.ConditionFalse, "PodsFailed",
+			fmt.Sprintf("loading the agent failed in %d of %d pods", failed, len(status.Pods)))
+	} else if pending > 0 {
+		status.SetCondition(v1alpha1.ConditionReady, v1.ConditionFalse, "PodsPending",
+			fmt.Sprintf("%d of %d pods are pending", pending, len(status.Pods)))
+	} else {
+		status.SetCondition(v1alpha1.ConditionReady, v1.ConditionTrue, "PodsInjected", "")
+	}
+}
+
+// createPodStatus returns the injection state of pod derived from its annotations.
+// In startup mode the pods not instrumented at creation are skipped.
+func createPodStatus(pod *v1.Pod, startupMode bool) v1alpha1.PodStatus {
+	podStatus := v1alpha1.PodStatus{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		LastError: pod.Annotations[prometheusJmxExporterLastErrorAnnotationKey],
+	}
+
+	switch pod.Annotations[prometheusJmxExporterAnnotationKey] {
+	case prometheusJmxExporterAnnotationVerified:
+		podStatus.Phase = v1alpha1.PodInjected
+	case prometheusJmxExporterAnnotationVerifiedFailed:
+		podStatus.Phase = v1alpha1.PodFailed
+	default:
+		if startupMode {
+			podStatus.Phase = v1alpha1.PodSkipped
+		} else {
+			podStatus.Phase = v1alpha1.PodPending
+		}
+	}
+
+	if attachTime, err := time.Parse(time.RFC3339, pod.Annotations[prometheusJmxExporterAttachTimeAnnotationKey]); err == nil {
+		// metav1.Time is unmarshalled in local time
+		t := metav1.NewTime(attachTime.Local())
+		podStatus.AttachTime = &t
+	}
+
+	podStatus.Attempts = failedAttempts(pod)
+	if retryTime, ok := nextRetryTime(pod); ok {
+		t := metav1.NewTime(retryTime.Local())
+		podStatus.NextRetryTime = &t
+	}
+
+	for _, endpoint := range createMetricEndpoints(pod) {
+		podStatus.Processes = append(podStatus.Processes, v1alpha1.ProcessStatus{
+			Container: endpoint.Container,
+			Pid:       endpoint.Pid,
+		})
+	}
+
+	return podStatus
+}
+
+// updatePodStatus adds or updates the injection state of pod in status.
+// Returns true if status is changed otherwise returns false.
+func updatePodStatus(status *v1alpha1.PrometheusJmxExporterStatus, pod *v1.Pod, startupMode bool) bool {
+	podStatus := createPodStatus(pod, startupMode)
+
+	for i := range status.Pods {
+		if status.Pods[i].Pod == pod.Name && isInNamespace(status.Pods[i].Namespace, pod) {
+			if reflect.DeepEqual(status.Pods[i], podStatus) {
+				return false
+			}
+
+			status.Pods[i] = podStatus
+			return true
+		}
+	}
+
+	status.Pods = append(status.Pods, podStatus)
+	return true
+}
+
+// removePodStatus removes the injection state of pod from status
+func removePodStatus(status *v1alpha1.PrometheusJmxExporterStatus, pod *v1.Pod) {
+	var pods []v1alpha1.PodStatus
+	for _, podStatus := range status.Pods {
+		if podStatus.Pod != pod.Name || !isInNamespace(podStatus.Namespace, pod) {
+			pods = append(pods, podStatus)
+		}
+	}
+
+	status.Pods = pods
+}
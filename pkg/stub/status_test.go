@@ -0,0 +1,304 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"testing"
+)
+
+// newAnnotatedPod returns a pod of the default namespace with annotations
+func newAnnotatedPod(name string, annotations map[string]string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+	}
+}
+
+var injectedPodAnnotations = map[string]string{
+	prometheusJmxExporterAnnotationKey:           prometheusJmxExporterAnnotationVerified,
+	prometheusJmxExporterAttachTimeAnnotationKey: "2018-06-01T10:00:00Z",
+	prometheusScrapeAnnotationKey:                "true",
+	prometheusPortAnnotationKey:                  "9020",
+	prometheusJmxExporterEndpointsAnnotationKey:  "app/42:9020",
+}
+
+func TestSetPodStatuses(t *testing.T) {
+	pods := []v1.Pod{
+		newAnnotatedPod("injected", injectedPodAnnotations),
+		newAnnotatedPod("failed", map[string]string{
+			prometheusJmxExporterAnnotationKey:          prometheusJmxExporterAnnotationVerifiedFailed,
+			prometheusJmxExporterLastErrorAnnotationKey: "no java process found",
+			prometheusJmxExporterAttemptsAnnotationKey:  "2",
+		}),
+		newAnnotatedPod("pending", nil),
+	}
+
+	var status v1alpha1.PrometheusJmxExporterStatus
+	setPodStatuses(&status, pods, false)
+
+	if len(status.MetricsEndpoints) != 1 {
+		t.Fatalf("expected 1 endpoint, got %d", len(status.MetricsEndpoints))
+	}
+	endpoint := status.MetricsEndpoints[0]
+	if endpoint.Namespace != "default" || endpoint.Pod != "injected" || endpoint.Container != "app" ||
+		endpoint.Pid != "42" || endpoint.Port != 9020 {
+		t.Errorf("unexpected endpoint %+v", endpoint)
+	}
+
+	expectedPhases := map[string]v1alpha1.PodPhase{
+		"injected": v1alpha1.PodInjected,
+		"failed":   v1alpha1.PodFailed,
+		"pending":  v1alpha1.PodPending,
+	}
+	if len(status.Pods) != len(expectedPhases) {
+		t.Fatalf("expected %d pod statuses, got %d", len(expectedPhases), len(status.Pods))
+	}
+	for _, podStatus := range status.Pods {
+		if podStatus.Phase != expectedPhases[podStatus.Pod] {
+			t.Errorf("expected phase %s of pod '%s', got %s", expectedPhases[podStatus.Pod], podStatus.Pod, podStatus.Phase)
+		}
+	}
+
+	failed := status.Pods[1]
+	if failed.LastError != "no java process found" || failed.Attempts != 2 {
+		t.Errorf("unexpected status of failed pod %+v", failed)
+	}
+
+	injected := status.Pods[0]
+	if injected.AttachTime == nil || len(injected.Processes) != 1 || injected.Processes[0].Pid != "42" {
+		t.Errorf("unexpected status of injected pod %+v", injected)
+	}
+}
+
+func TestSetPodStatusesStartupMode(t *testing.T) {
+	var status v1alpha1.PrometheusJmxExporterStatus
+	setPodStatuses(&status, []v1.Pod{newAnnotatedPod("not-instrumented", nil)}, true)
+
+	if len(status.Pods) != 1 || status.Pods[0].Phase != v1alpha1.PodSkipped {
+		t.Errorf("expected pod skipped in startup mode, got %+v", status.Pods)
+	}
+}
+
+func TestUpdatePodStatus(t *testing.T) {
+	var status v1alpha1.PrometheusJmxExporterStatus
+
+	pending := newAnnotatedPod("app-1", nil)
+	if !updatePodStatus(&status, &pending, false) {
+		t.Error("expected status changed when adding a pod")
+	}
+	if updatePodStatus(&status, &pending, false) {
+		t.Error("expected status unchanged when the pod is unchanged")
+	}
+
+	injected := newAnnotatedPod("app-1", injectedPodAnnotations)
+	if !updatePodStatus(&status, &injected, false) {
+		t.Error("expected status changed when the pod is injected")
+	}
+
+	if len(status.Pods) != 1 || status.Pods[0].Phase != v1alpha1.PodInjected {
+		t.Errorf("expected single injected pod, got %+v", status.Pods)
+	}
+
+	removePodStatus(&status, &injected)
+	if len(status.Pods) != 0 {
+		t.Errorf("expected pod removed, got %+v", status.Pods)
+	}
+}
+
+func TestUpdateReadyConditions(t *testing.T) {
+	tests := []struct {
+		name          string
+		configErr     error
+		conflictErr   error
+		phases        []v1alpha1.PodPhase
+		unhealthy     bool
+		ready         v1.ConditionStatus
+		readyReason   string
+		degraded      v1.ConditionStatus
+		degradedCause string
+	}{
+		{name: "injected", phases: []v1alpha1.PodPhase{v1alpha1.PodInjected}, ready: v1.ConditionTrue, readyReason: "PodsInjected", degraded: v1.ConditionFalse, degradedCause: "NoPodsFailed"},
+		{name: "pending", phases: []v1alpha1.PodPhase{v1alpha1.PodInjected, v1alpha1.PodPending}, ready: v1.ConditionFalse, readyReason: "PodsPending", degraded: v1.ConditionFalse, degradedCause: "NoPodsFailed"},
+		{name: "failed", phases: []v1alpha1.PodPhase{v1alpha1.PodFailed}, ready: v1.ConditionFalse, readyReason: "PodsFailed", degraded: v1.ConditionTrue, degradedCause: "PodsFailed"},
+		{name: "unhealthy", phases: []v1alpha1.PodPhase{v1alpha1.PodInjected}, unhealthy: true, ready: v1.ConditionTrue, readyReason: "PodsInjected", degraded: v1.ConditionTrue, degradedCause: "EndpointsUnhealthy"},
+		{name: "invalid config", configErr: fmt.Errorf("invalid"), ready: v1.ConditionFalse, readyReason: "ConfigInvalid", degraded: v1.ConditionFalse, degradedCause: "NoPodsFailed"},
+		{name: "conflict", conflictErr: fmt.Errorf("conflict"), ready: v1.ConditionFalse, readyReason: "Conflict", degraded: v1.ConditionFalse, degradedCause: "NoPodsFailed"},
+	}
+
+	for _, test := range tests {
+		var status v1alpha1.PrometheusJmxExporterStatus
+		setConfigValidCondition(&status, test.configErr)
+		setConflictCondition(&status, test.conflictErr, nil)
+
+		for i, phase := range test.phases {
+			status.Pods = append(status.Pods, v1alpha1.PodStatus{Pod: fmt.Sprintf("app-%d", i), Phase: phase})
+		}
+		if test.unhealthy {
+			status.MetricsEndpoints = append(status.MetricsEndpoints, &v1alpha1.MetricsEndpoint{Pod: "app-0", Health: v1alpha1.EndpointUnhealthy})
+		}
+
+		updateReadyConditions(&status)
+
+		if c := status.GetCondition(v1alpha1.ConditionReady); c == nil || c.Status != test.ready || c.Reason != test.readyReason {
+			t.Errorf("%s: expected Ready %s/%s, got %+v", test.name, test.ready, test.readyReason, c)
+		}
+		if c := status.GetCondition(v1alpha1.ConditionDegraded); c == nil || c.Status != test.degraded || c.Reason != test.degradedCause {
+			t.Errorf("%s: expected Degraded %s/%s, got %+v", test.name, test.degraded, test.degradedCause, c)
+		}
+	}
+}
+
+func TestUpdatePrometheusJmxExporterStatus(t *testing.T) {
+	api := installFakeAPI()
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	api.store("prometheusjmxexporters", prometheusJmxExporter)
+
+	status := newPrometheusJmxExporterStatus(prometheusJmxExporter.Status, 1)
+	setPodStatuses(&status, []v1.Pod{newAnnotatedPod("app-1", injectedPodAnnotations)}, false)
+	updateReadyConditions(&status)
+
+	if err := updatePrometheusJmxExporterStatus(prometheusJmxExporter, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stored v1alpha1.PrometheusJmxExporter
+	api.get(t, "prometheusjmxexporters", "default", "test", &stored)
+
+	if stored.Status.ObservedGeneration != 1 || len(stored.Status.Pods) != 1 || len(stored.Status.MetricsEndpoints) != 1 {
+		t.Errorf("unexpected stored status %+v", stored.Status)
+	}
+
+	// storing the same status again is skipped
+	if err := updatePrometheusJmxExporterStatus(&stored, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updates := api.updateCount("prometheusjmxexporters", "default", "test"); updates != 1 {
+		t.Errorf("expected 1 update, got %d", updates)
+	}
+}
+
+func TestStorePrometheusJmxExporterStatusMergesNamespaces(t *testing.T) {
+	api := installFakeAPI()
+
+	clusterPrometheusJmxExporter := &v1alpha1.ClusterPrometheusJmxExporter{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       v1alpha1.ClusterPrometheusJmxExporterKind,
+			APIVersion: "banzaicloud.com/v1alpha1",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Spec: v1alpha1.ClusterPrometheusJmxExporterSpec{
+			PrometheusJmxExporterSpec: v1alpha1.PrometheusJmxExporterSpec{Port: 9020},
+		},
+	}
+	api.store("clusterprometheusjmxexporters", clusterPrometheusJmxExporter)
+
+	// both namespaces are derived from the same version of the ClusterPrometheusJmxExporter
+	prod := clusterPrometheusJmxExporter.ForNamespace("prod")
+	dev := clusterPrometheusJmxExporter.ForNamespace("dev")
+
+	prodPod := newAnnotatedPod("app-1", injectedPodAnnotations)
+	prodPod.Namespace = "prod"
+	err := storePrometheusJmxExporterStatus(prod, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+		updatePrometheusJmxExporterEndpoints(latest, &prodPod)
+		return updatePodStatus(&latest.Status, &prodPod, false)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	devPod := newAnnotatedPod("app-2", nil)
+	devPod.Namespace = "dev"
+	api.conflict("clusterprometheusjmxexporters", "", "cluster", 2)
+	err = storePrometheusJmxExporterStatus(dev, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+		return updatePodStatus(&latest.Status, &devPod, false)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var stored v1alpha1.ClusterPrometheusJmxExporter
+	api.get(t, "clusterprometheusjmxexporters", "", "cluster", &stored)
+
+	phases := make(map[string]v1alpha1.PodPhase)
+	for _, podStatus := range stored.Status.Pods {
+		phases[podStatus.Namespace+"/"+podStatus.Pod] = podStatus.Phase
+	}
+	expected := map[string]v1alpha1.PodPhase{"prod/app-1": v1alpha1.PodInjected, "dev/app-2": v1alpha1.PodPending}
+	if !reflect.DeepEqual(phases, expected) {
+		t.Errorf("expected pods %v, got %v", expected, phases)
+	}
+
+	if len(stored.Status.MetricsEndpoints) != 1 || stored.Status.MetricsEndpoints[0].Namespace != "prod" {
+		t.Errorf("expected the endpoint of prod kept, got %+v", stored.Status.MetricsEndpoints)
+	}
+	if c := stored.Status.GetCondition(v1alpha1.ConditionReady); c == nil || c.Reason != "PodsPending" {
+		t.Errorf("expected ready condition computed from all namespaces, got %+v", c)
+	}
+}
+
+func TestStorePrometheusJmxExporterStatusAppliesToLatest(t *testing.T) {
+	api := installFakeAPI()
+
+	prometheusJmxExporter := newTestPrometheusJmxExporter()
+	api.store("prometheusjmxexporters", prometheusJmxExporter)
+
+	// both pods are stored through the same stale copy of the exporter
+	cached := prometheusJmxExporter.DeepCopy()
+
+	for _, name := range []string{"app-1", "app-2"} {
+		pod := newAnnotatedPod(name, injectedPodAnnotations)
+
+		api.conflict("prometheusjmxexporters", "default", "test", 1)
+		err := storePrometheusJmxExporterStatus(cached, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+			updatePrometheusJmxExporterEndpoints(latest, &pod)
+			return updatePodStatus(&latest.Status, &pod, false)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	var stored v1alpha1.PrometheusJmxExporter
+	api.get(t, "prometheusjmxexporters", "default", "test", &stored)
+
+	if len(stored.Status.Pods) != 2 || len(stored.Status.MetricsEndpoints) != 2 {
+		t.Errorf("expected the state of both pods stored, got %+v", stored.Status)
+	}
+	if c := stored.Status.GetCondition(v1alpha1.ConditionReady); c == nil {
+		t.Error("expected ready condition computed")
+	}
+
+	// an unchanged status is not stored
+	pod := newAnnotatedPod("app-1", injectedPodAnnotations)
+	err := storePrometheusJmxExporterStatus(cached, func(latest *v1alpha1.PrometheusJmxExporter) bool {
+		return updatePodStatus(&latest.Status, &pod, false)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updates := api.updateCount("prometheusjmxexporters", "default", "test"); updates != 2 {
+		t.Errorf("expected 2 updates, got %d", updates)
+	}
+}
+
+func TestSetConflictConditionOverlap(t *testing.T) {
+	var status v1alpha1.PrometheusJmxExporterStatus
+	setConflictCondition(&status, nil, []string{"other"})
+
+	c := status.GetCondition(v1alpha1.ConditionConflict)
+	if c == nil || c.Status != v1.ConditionFalse || c.Reason != "SelectorsOverlap" {
+		t.Fatalf("expected overlap reported without conflict, got %+v", c)
+	}
+
+	updateReadyConditions(&status)
+	if c := status.GetCondition(v1alpha1.ConditionReady); c == nil || c.Reason == "Conflict" {
+		t.Errorf("expected overlapping selectors not to make the exporter unready, got %+v", c)
+	}
+}
@@ -0,0 +1,371 @@
+package stub
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileTransfer is a strategy to upload files into a container
+type fileTransfer string
+
+const (
+	// tarTransfer extracts a tar stream of the files with tar
+	tarTransfer fileTransfer = "tar"
+	// base64Transfer decodes each file streamed in base64 with base64 -d
+	base64Transfer fileTransfer = "base64"
+	// ddTransfer writes each file streamed as is with dd
+	ddTransfer fileTransfer = "dd"
+)
+
+// fileTransfers are the file transfers in the order of preference, each of them is named after the tool it needs
+var fileTransfers = []fileTransfer{tarTransfer, base64Transfer, ddTransfer}
+
+// tarGzipTool is reported by the tool probe if tar supports the z flag to extract gzip compressed streams
+const tarGzipTool = "tar-z"
+
+// toolProbeScript prints the names of the tools used to copy and verify files found in the container
+const toolProbeScript = `for tool in tar base64 dd wc sha256sum; do command -v $tool >/dev/null 2>&1 && echo $tool; done
+case "$(tar --help 2>&1)" in *-z*) echo tar-z;; esac; true`
+
+// fileSumScript prints the size and sha256 checksum of each file given as argument in '<size> <sha256> <file>' format.
+// The checksum is '-' if sha256sum is not available, missing files are skipped.
+const fileSumScript = `for file in "$@"; do
+  [ -f "$file" ] || continue
+  size=$(wc -c < "$file")
+  sum=-
+  if command -v sha256sum >/dev/null 2>&1; then sum=$(sha256sum < "$file"); fi
+  echo $size ${sum%% *} "$file"
+done`
+
+const (
+	// base64UploadScript and ddUploadScript write their input into the file given as argument
+	base64UploadScript = `base64 -d > "$0"`
+	ddUploadScript     = `dd of="$0" 2>/dev/null`
+)
+
+var (
+	jarsManifestMutex sync.Mutex
+	// jarsManifest lists the files of prometheusJmxExporterSrcJarsDir. These are part of the image
+	// of the operator thus their checksums are computed only once.
+	jarsManifest []sourceFile
+)
+
+// sourceFile is a regular file to upload into a container
+type sourceFile struct {
+	// relPath is the path of the file relative to the source and destination directories
+	relPath   string
+	localPath string
+	size      int64
+	sha256    string
+	mode      os.FileMode
+}
+
+// containerTransfer is the way files are copied into a container. The tools of the container are probed once,
+// the copies into the container share the outcome.
+type containerTransfer struct {
+	// tools are the tools found in the container which are used to copy and verify files
+	tools map[string]bool
+	// strategy is the most preferred file transfer the tools allow
+	strategy fileTransfer
+}
+
+// newContainerTransfer probes the tools of container and selects the file transfer. If the probe can't run,
+// e.g. the container has no shell, the files are extracted by running tar directly and they are not verified.
+func newContainerTransfer(executor PodExecutor, namespace, podName, container string) (*containerTransfer, error) {
+	tools, err := probeTools(executor, namespace, podName, container)
+	if err != nil {
+		logrus.Warnf("Probing tools of container '%s/%s/%s' failed, falling back to %s transfer: %v",
+			namespace, podName, container, tarTransfer, err)
+
+		tools = map[string]bool{string(tarTransfer): true}
+	}
+
+	strategy, err := selectFileTransfer(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerTransfer{tools: tools, strategy: strategy}, nil
+}
+
+// String describes the strategy of transfer and whether the files are verified
+func (transfer *containerTransfer) String() string {
+	switch {
+	case !transfer.tools["wc"]:
+		return fmt.Sprintf("%s transfer without verification", transfer.strategy)
+	case !transfer.tools["sha256sum"]:
+		return fmt.Sprintf("%s transfer verified by size", transfer.strategy)
+	default:
+		return fmt.Sprintf("%s transfer verified by sha256 checksum", transfer.strategy)
+	}
+}
+
+// probeTools returns the tools found in container which are used to copy and verify files
+func probeTools(executor PodExecutor, namespace, podName, container string) (map[string]bool, error) {
+	stdout, err := execWith(executor, namespace, podName, container, nil, "sh", "-c", toolProbeScript)
+	if err != nil {
+		return nil, err
+	}
+
+	tools := make(map[string]bool)
+	for _, tool := range strings.Fields(stdout) {
+		tools[tool] = true
+	}
+
+	return tools, nil
+}
+
+// selectFileTransfer returns the most preferred file transfer the tools found in the container allow
+func selectFileTransfer(tools map[string]bool) (fileTransfer, error) {
+	for _, transfer := range fileTransfers {
+		if tools[string(transfer)] {
+			return transfer, nil
+		}
+	}
+
+	return "", fmt.Errorf("none of %v found in container, files can't be copied", fileTransfers)
+}
+
+// sourceManifest returns the regular files of srcDir with their size and checksum
+func sourceManifest(srcDir string) ([]sourceFile, error) {
+	if srcDir != prometheusJmxExporterSrcJarsDir {
+		return listSourceFiles(srcDir)
+	}
+
+	jarsManifestMutex.Lock()
+	defer jarsManifestMutex.Unlock()
+
+	if jarsManifest == nil {
+		files, err := listSourceFiles(srcDir)
+		if err != nil {
+			return nil, err
+		}
+		jarsManifest = files
+	}
+
+	return jarsManifest, nil
+}
+
+// changedFiles returns the files which are missing under destDir on container or differ from the source files.
+// All files are returned if the checksums of the files in the container can't be computed with the tools found in it.
+func changedFiles(executor PodExecutor, namespace, podName, container string, tools map[string]bool, files []sourceFile, destDir string) ([]sourceFile, error) {
+	if !tools["wc"] || !tools["sha256sum"] {
+		return files, nil
+	}
+
+	sums, err := queryFileSums(executor, namespace, podName, container, files, destDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []sourceFile
+	for _, file := range files {
+		sum, ok := sums[path.Join(destDir, file.relPath)]
+		if !ok || sum.size != file.size || sum.sha256 != file.sha256 {
+			changed = append(changed, file)
+		}
+	}
+
+	return changed, nil
+}
+
+// listSourceFiles returns the regular files of srcDir with their size and checksum
+func listSourceFiles(srcDir string) ([]sourceFile, error) {
+	var files []sourceFile
+
+	err := filepath.Walk(srcDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		relPath, err := filepath.Rel(srcDir, filePath)
+		if err != nil {
+			return err
+		}
+
+		sum, err := fileChecksum(filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, sourceFile{
+			relPath:   filepath.ToSlash(relPath),
+			localPath: filePath,
+			size:      info.Size(),
+			sha256:    sum,
+			mode:      info.Mode().Perm(),
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+// fileChecksum returns the hex encoded sha256 checksum of the file at filePath
+func fileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// copyFileByFile uploads files to destDir on container one by one with transfer, which is either base64Transfer
+// or ddTransfer, and sets their permissions
+func copyFileByFile(executor PodExecutor, namespace, podName, container string, transfer fileTransfer, files []sourceFile, destDir string) error {
+	dirs := make(map[string]bool)
+	var mkdirCommand []string
+	for _, file := range files {
+		dir := path.Dir(path.Join(destDir, file.relPath))
+		if dir != destDir && !dirs[dir] {
+			dirs[dir] = true
+			mkdirCommand = append(mkdirCommand, dir)
+		}
+	}
+
+	if len(mkdirCommand) > 0 {
+		if _, err := execWith(executor, namespace, podName, container, nil, append([]string{"mkdir", "-p"}, mkdirCommand...)...); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		destPath := path.Join(destDir, file.relPath)
+
+		if err := uploadFile(executor, namespace, podName, container, transfer, file.localPath, destPath); err != nil {
+			return fmt.Errorf("uploading '%s' failed: %v", destPath, err)
+		}
+
+		if _, err := execWith(executor, namespace, podName, container, nil,
+			"chmod", strconv.FormatUint(uint64(file.mode), 8), destPath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadFile writes the content of the local file localPath into destPath on container with transfer
+func uploadFile(executor PodExecutor, namespace, podName, container string, transfer fileTransfer, localPath, destPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var stdin io.Reader = f
+	var script string
+
+	switch transfer {
+	case base64Transfer:
+		reader, writer := io.Pipe()
+		go func() {
+			encoder := base64.NewEncoder(base64.StdEncoding, writer)
+			_, err := io.Copy(encoder, f)
+			if err == nil {
+				err = encoder.Close()
+			}
+			writer.CloseWithError(err)
+		}()
+
+		stdin = reader
+		script = base64UploadScript
+	case ddTransfer:
+		script = ddUploadScript
+	default:
+		return fmt.Errorf("unsupported file transfer %s", transfer)
+	}
+
+	_, err = execWith(executor, namespace, podName, container, stdin, "sh", "-c", script, destPath)
+	return err
+}
+
+// verifyFiles checks that the size and the checksum, if sha256sum is available, of the files copied to destDir
+// on container match the ones of the source files
+func verifyFiles(executor PodExecutor, namespace, podName, container string, files []sourceFile, destDir string) error {
+	sums, err := queryFileSums(executor, namespace, podName, container, files, destDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		destPath := path.Join(destDir, file.relPath)
+
+		sum, ok := sums[destPath]
+		if !ok {
+			return fmt.Errorf("'%s' not found", destPath)
+		}
+		if sum.size != file.size {
+			return fmt.Errorf("size of '%s' is %d, expected %d", destPath, sum.size, file.size)
+		}
+		if len(sum.sha256) > 0 && sum.sha256 != file.sha256 {
+			return fmt.Errorf("checksum of '%s' is %s, expected %s", destPath, sum.sha256, file.sha256)
+		}
+	}
+
+	return nil
+}
+
+// remoteFileSum is the size and the checksum of a file in a container, the checksum is empty if unknown
+type remoteFileSum struct {
+	size   int64
+	sha256 string
+}
+
+// queryFileSums returns the size and checksum of files under destDir on container by path
+func queryFileSums(executor PodExecutor, namespace, podName, container string, files []sourceFile, destDir string) (map[string]remoteFileSum, error) {
+	command := []string{"sh", "-c", fileSumScript, "sh"}
+	for _, file := range files {
+		command = append(command, path.Join(destDir, file.relPath))
+	}
+
+	stdout, err := execWith(executor, namespace, podName, container, nil, command...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFileSums(stdout), nil
+}
+
+// parseFileSums parses the output of fileSumScript, malformed lines are skipped
+func parseFileSums(output string) map[string]remoteFileSum {
+	sums := make(map[string]remoteFileSum)
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		sum := remoteFileSum{size: size}
+		if fields[1] != "-" {
+			sum.sha256 = fields[1]
+		}
+
+		sums[strings.Join(fields[2:], " ")] = sum
+	}
+
+	return sums
+}
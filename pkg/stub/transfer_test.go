@@ -0,0 +1,202 @@
+package stub
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countCommands returns the number of commands executed in the container identified by key which start with prefix
+func countCommands(executor *fakePodExecutor, key, prefix string) int {
+	count := 0
+	for _, command := range executor.Commands[key] {
+		if strings.HasPrefix(command, prefix) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// checkCopiedJars checks that the jars and the attacher copied into the container identified by key match the source files
+func checkCopiedJars(t *testing.T, name string, executor *fakePodExecutor, key string) {
+	for _, file := range []string{prometheusJmxExporterLoaderJar, prometheusJmxExporterAgentJar, prometheusJmxExporterAttacherBinary} {
+		expected, err := ioutil.ReadFile(filepath.Join(prometheusJmxExporterSrcJarsDir, file))
+		if err != nil {
+			t.Fatalf("reading %s failed: %v", file, err)
+		}
+
+		if content := executor.Files[key][path.Join(prometheusJmxExporterTargetDir, file)]; !bytes.Equal(content, expected) {
+			t.Errorf("%s: expected %s copied as '%s', got '%s'", name, file, expected, content)
+		}
+	}
+}
+
+func TestCopyFilesStrategies(t *testing.T) {
+	key := fakeContainerKey("default", "app-1", "app")
+
+	tests := []struct {
+		name         string
+		missingTools []string
+		probeErr     error
+		strategy     fileTransfer
+		// command is the prefix of the commands uploading the files
+		command  string
+		verified bool
+	}{
+		{name: "tar", strategy: tarTransfer, command: "tar xzfm", verified: true},
+		{name: "tar without gzip", missingTools: []string{tarGzipTool}, strategy: tarTransfer, command: "tar xfm", verified: true},
+		{name: "base64", missingTools: []string{"tar"}, strategy: base64Transfer, command: "sh -c " + base64UploadScript, verified: true},
+		{name: "dd", missingTools: []string{"tar", "base64"}, strategy: ddTransfer, command: "sh -c " + ddUploadScript, verified: true},
+		{name: "dd without sha256sum", missingTools: []string{"tar", "base64", "sha256sum"}, strategy: ddTransfer, command: "sh -c " + ddUploadScript, verified: true},
+		{name: "no shell", probeErr: fmt.Errorf("exec: \"sh\": executable file not found in $PATH"), strategy: tarTransfer, command: "tar xfm"},
+	}
+
+	for _, test := range tests {
+		executor := newFakePodExecutor()
+		for _, tool := range test.missingTools {
+			executor.MissingTools[tool] = true
+		}
+		if test.probeErr != nil {
+			executor.Errors["probe"] = test.probeErr
+		}
+
+		transfer, err := newContainerTransfer(executor, "default", "app-1", "app")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if transfer.strategy != test.strategy {
+			t.Errorf("%s: expected %s transfer, got %s", test.name, test.strategy, transfer.strategy)
+		}
+
+		if err := copyFiles(executor, "default", "app-1", "app", transfer, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		checkCopiedJars(t, test.name, executor, key)
+
+		if uploads := countCommands(executor, key, test.command); uploads == 0 {
+			t.Errorf("%s: expected files uploaded by '%s', got %v", test.name, test.command, executor.Commands[key])
+		}
+		if verified := countCommands(executor, key, "sh -c "+fileSumScript) > 0; verified != test.verified {
+			t.Errorf("%s: expected files verified %v, got %v", test.name, test.verified, verified)
+		}
+	}
+}
+
+func TestCopyFilesWithoutTools(t *testing.T) {
+	executor := newFakePodExecutor()
+	for _, transfer := range fileTransfers {
+		executor.MissingTools[string(transfer)] = true
+	}
+
+	if _, err := newContainerTransfer(executor, "default", "app-1", "app"); err == nil {
+		t.Error("expected error for a container without tar, base64 and dd")
+	}
+}
+
+func TestCopyFilesSkipsUpToDateFiles(t *testing.T) {
+	executor := newFakePodExecutor()
+	key := fakeContainerKey("default", "app-1", "app")
+
+	transfer, err := newContainerTransfer(executor, "default", "app-1", "app")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := copyFiles(executor, "default", "app-1", "app", transfer, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if uploads := countCommands(executor, key, "tar "); uploads != 1 {
+		t.Errorf("expected the files uploaded once, got %d uploads", uploads)
+	}
+
+	// a differing file is uploaded again
+	agentPath := path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAgentJar)
+	executor.Files[key][agentPath] = []byte("changed")
+
+	if err := copyFiles(executor, "default", "app-1", "app", transfer, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if uploads := countCommands(executor, key, "tar "); uploads != 2 {
+		t.Errorf("expected the changed file uploaded again, got %d uploads", uploads)
+	}
+	checkCopiedJars(t, "changed file", executor, key)
+}
+
+func TestCopyFilesVerification(t *testing.T) {
+	agentPath := path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterAgentJar)
+
+	for _, strategy := range fileTransfers {
+		executor := newFakePodExecutor()
+		executor.Corrupt[agentPath] = true
+
+		transfer := &containerTransfer{
+			tools:    map[string]bool{string(strategy): true, "wc": true, "sha256sum": true},
+			strategy: strategy,
+		}
+
+		err := copyFiles(executor, "default", "app-1", "app", transfer, prometheusJmxExporterSrcJarsDir, prometheusJmxExporterTargetDir)
+		if err == nil || !strings.Contains(err.Error(), agentPath) {
+			t.Errorf("%s: expected verification of '%s' failed, got %v", strategy, agentPath, err)
+		}
+	}
+}
+
+func TestProcessPodProbesToolsOnce(t *testing.T) {
+	api := installFakeAPI()
+	executor := newFakePodExecutor()
+	handler := NewHandler(executor)
+
+	pod := newTestPod(api, "app-1", "app")
+	key := fakeContainerKey("default", "app-1", "app")
+	executor.JpsOutput[key] = "42 com.example.App\n"
+
+	if err := handler.processPod(pod, testConfig, newTestPrometheusJmxExporter()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if probes := countCommands(executor, key, "sh -c "+toolProbeScript); probes != 1 {
+		t.Errorf("expected the tools probed once for the jars and the config, got %d probes", probes)
+	}
+
+	confPath := path.Join(prometheusJmxExporterTargetDir, prometheusJmxExporterTargetConfDir, prometheusJmxExportedConfigFilename)
+	if _, ok := executor.Files[key][confPath]; !ok {
+		t.Errorf("expected config copied to '%s'", confPath)
+	}
+	checkCopiedJars(t, "process pod", executor, key)
+}
+
+func TestParseFileSums(t *testing.T) {
+	output := strings.Join([]string{
+		"12 abc /opt/jmx-exporter-loader/agent.jar",
+		"7 - /opt/jmx-exporter-loader/conf/config.yaml",
+		"malformed",
+		"x abc /opt/file",
+	}, "\n")
+
+	expected := map[string]remoteFileSum{
+		"/opt/jmx-exporter-loader/agent.jar":        {size: 12, sha256: "abc"},
+		"/opt/jmx-exporter-loader/conf/config.yaml": {size: 7},
+	}
+
+	sums := parseFileSums(output)
+	if len(sums) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, sums)
+	}
+	for file, sum := range expected {
+		if sums[file] != sum {
+			t.Errorf("expected %+v for '%s', got %+v", sum, file, sums[file])
+		}
+	}
+}
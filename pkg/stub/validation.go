@@ -0,0 +1,195 @@
+package stub
+
+import (
+	"fmt"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// javaOnlyRegexConstructs matches the constructs of java.util.regex.Pattern that RE2 doesn't support
+var javaOnlyRegexConstructs = regexp.MustCompile(`\(\?<?[=!]|\(\?<[a-zA-Z]|\(\?>|\\[1-9]|\\k<|[*+?}]\+`)
+
+// validatePrometheusJmxExporter checks the spec of prometheusJmxExporter and the prometheus jmx exporter
+// config it refers to. Returns an error describing all problems found.
+func validatePrometheusJmxExporter(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) error {
+	var problems []string
+	spec := &prometheusJmxExporter.Spec
+
+	if len(spec.LabelSelector) > 0 && spec.Selector != nil {
+		problems = append(problems, "labelSelector and selector are mutually exclusive")
+	}
+	if isEmptySelector(spec.PodSelector()) {
+		problems = append(problems, "selector must not be empty")
+	} else if _, err := podSelector(prometheusJmxExporter); err != nil {
+		problems = append(problems, fmt.Sprintf("selector is invalid: %v", err))
+	}
+
+	if spec.PortRange != nil {
+		if !isValidPort(spec.PortRange.From) || !isValidPort(spec.PortRange.To) || spec.PortRange.From > spec.PortRange.To {
+			problems = append(problems, fmt.Sprintf("portRange %d-%d is invalid", spec.PortRange.From, spec.PortRange.To))
+		}
+	} else if !isValidPort(spec.Port) {
+		problems = append(problems, fmt.Sprintf("port %d is out of range 1-65535", spec.Port))
+	}
+
+	if len(spec.Mode) > 0 && spec.Mode != v1alpha1.InjectionModeAttach && spec.Mode != v1alpha1.InjectionModeStartup {
+		problems = append(problems, fmt.Sprintf("unknown mode '%s'", spec.Mode))
+	}
+
+	if spec.RetryPolicy != nil {
+		problems = append(problems, validateRetryPolicy(spec.RetryPolicy)...)
+	}
+
+	if err := spec.Config.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		config, err := loadConfig(prometheusJmxExporter)
+		if apierrors.IsNotFound(err) {
+			// the configMap may be created after the prometheusJmxExporter
+			logrus.Warnf("Validating config of '%s/%s' skipped: %v", prometheusJmxExporter.Namespace, prometheusJmxExporter.Name, err)
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = append(problems, validateConfig(config, spec.IsStartupMode())...)
+		}
+	}
+
+	// selectors which may select the same pods are only reported in the status until a pod is selected by both
+	conflicts, err := findSelectorConflicts(prometheusJmxExporter)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, conflicts...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateConfig checks the prometheus jmx exporter config. In attach mode the agent talks to the local JVM
+// thus hostPort and jmxUrl must not be set.
+func validateConfig(config *v1alpha1.PrometheusJmxExporterConfig, startupMode bool) []string {
+	var problems []string
+
+	if !startupMode {
+		if config.HostPort != nil {
+			problems = append(problems, "hostPort must not be set as the agent is loaded into the java process")
+		}
+		if config.JmxUrl != nil {
+			problems = append(problems, "jmxUrl must not be set as the agent is loaded into the java process")
+		}
+	}
+
+	for i, rule := range config.Rules {
+		if rule.Pattern == nil {
+			continue
+		}
+
+		if err := validateJavaRegex(*rule.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("pattern of rule %d is invalid: %v", i, err))
+		}
+	}
+
+	return problems
+}
+
+// validateJavaRegex returns an error if pattern is not a valid java regular expression. Patterns are checked
+// with RE2, errors caused by the constructs only java supports (lookarounds, backreferences, atomic groups,
+// possessive quantifiers) are ignored.
+func validateJavaRegex(pattern string) error {
+	_, err := regexp.Compile(pattern)
+	if err == nil {
+		return nil
+	}
+
+	if syntaxErr, ok := err.(*syntax.Error); ok {
+		switch syntaxErr.Code {
+		case syntax.ErrInvalidPerlOp, syntax.ErrInvalidEscape, syntax.ErrInvalidRepeatOp, syntax.ErrMissingRepeatArgument:
+			if javaOnlyRegexConstructs.MatchString(pattern) {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// findOverlappingExporters returns the other prometheusJmxExporters in the namespace
+// of prometheusJmxExporter which may select the same pods
+func findOverlappingExporters(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) ([]v1alpha1.PrometheusJmxExporter, error) {
+	prometheusJmxExporters, err := queryPrometheusJmxExporters(prometheusJmxExporter.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlapping []v1alpha1.PrometheusJmxExporter
+	for i := 0; i < len(prometheusJmxExporters.Items); i++ {
+		other := &prometheusJmxExporters.Items[i]
+
+		if isSameExporter(other, prometheusJmxExporter) || other.DeletionTimestamp != nil {
+			continue
+		}
+
+		if selectorsOverlap(prometheusJmxExporter.Spec.PodSelector(), other.Spec.PodSelector()) {
+			overlapping = append(overlapping, *other)
+		}
+	}
+
+	return overlapping, nil
+}
+
+// findSelectorConflicts returns the description of the conflicts with the other prometheusJmxExporters in the
+// namespace of prometheusJmxExporter which select a pod selected by prometheusJmxExporter
+func findSelectorConflicts(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) ([]string, error) {
+	overlapping, err := findOverlappingExporters(prometheusJmxExporter)
+	if err != nil || len(overlapping) == 0 {
+		return nil, err
+	}
+
+	podList, err := queryPrometheusJmxExporterPods(prometheusJmxExporter)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for i := range overlapping {
+		for j := range podList.Items {
+			if selectsPod(&overlapping[i], &podList.Items[j]) {
+				conflicts = append(conflicts, fmt.Sprintf("pod '%s' is selected by prometheusjmxexporter '%s' as well",
+					podList.Items[j].Name, overlapping[i].Name))
+				break
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// overlappingExporterNames returns the names of the other prometheusJmxExporters which may select the same pods as
+// prometheusJmxExporter, these are reported in its status. Failing to look them up is not an error.
+func overlappingExporterNames(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) []string {
+	overlapping, err := findOverlappingExporters(prometheusJmxExporter)
+	if err != nil {
+		logrus.Warnf("Looking up the exporters overlapping with '%s/%s' failed: %v",
+			prometheusJmxExporter.Namespace, prometheusJmxExporter.Name, err)
+		return nil
+	}
+
+	var names []string
+	for _, other := range overlapping {
+		names = append(names, other.Name)
+	}
+
+	return names
+}
+
+// isValidPort returns true if port is a valid TCP port number
+func isValidPort(port int) bool {
+	return port > 0 && port <= 65535
+}
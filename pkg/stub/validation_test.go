@@ -0,0 +1,187 @@
+package stub
+
+import (
+	"encoding/json"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"k8s.io/api/admission/v1beta1"
+	"strings"
+	"testing"
+)
+
+func TestValidatePrometheusJmxExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter)
+		expected string
+	}{
+		{
+			name: "inline config",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = testConfig
+			},
+		},
+		{
+			name: "configMap",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+				prometheusJmxExporter.Spec.Config.ConfigMapKey = "config.yaml"
+			},
+		},
+		{
+			name: "configMap not created yet",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.ConfigMapName = "missing"
+				prometheusJmxExporter.Spec.Config.ConfigMapKey = "config.yaml"
+			},
+		},
+		{
+			name:     "no config",
+			update:   func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {},
+			expected: "either config.configMapName or config.inline must be set",
+		},
+		{
+			name: "inline config and configMap",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = testConfig
+				prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+			},
+			expected: "config.inline and config.configMapName are mutually exclusive",
+		},
+		{
+			name: "configMap without key",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+			},
+			expected: "config.configMapKey must be set",
+		},
+		{
+			name: "hostPort in attach mode",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{HostPort: stringPtr("localhost:5555")}
+			},
+			expected: "hostPort must not be set",
+		},
+		{
+			name: "hostPort in startup mode",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Mode = v1alpha1.InjectionModeStartup
+				prometheusJmxExporter.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{HostPort: stringPtr("localhost:5555")}
+			},
+		},
+		{
+			name: "invalid pattern",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{
+					Rules: []v1alpha1.PrometheusJmxExporterConfigRules{{Pattern: stringPtr(".*")}, {Pattern: stringPtr("kafka.server<(type")}},
+				}
+			},
+			expected: "pattern of rule 1 is invalid",
+		},
+		{
+			name: "java only pattern",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{
+					Rules: []v1alpha1.PrometheusJmxExporterConfigRules{{Pattern: stringPtr("kafka.(?!consumer)(\\w+)<>Value")}},
+				}
+			},
+		},
+		{
+			name: "invalid pattern in configMap",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.ConfigMapName = "jmx-config"
+				prometheusJmxExporter.Spec.Config.ConfigMapKey = "invalid.yaml"
+			},
+			expected: "pattern of rule 0 is invalid",
+		},
+		{
+			name: "port out of range",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = testConfig
+				prometheusJmxExporter.Spec.Port = 70000
+			},
+			expected: "port 70000 is out of range 1-65535",
+		},
+		{
+			name: "unknown mode",
+			update: func(prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) {
+				prometheusJmxExporter.Spec.Config.Inline = testConfig
+				prometheusJmxExporter.Spec.Mode = "sidecar"
+			},
+			expected: "unknown mode 'sidecar'",
+		},
+	}
+
+	api := installFakeAPI()
+	configMap := newTestConfigMap(api)
+	configMap.Data["invalid.yaml"] = "rules:\n- pattern: \"kafka.server<(type\"\n"
+	api.store("configmaps", configMap)
+	installFakeCache(t)
+
+	for _, test := range tests {
+		prometheusJmxExporter := newTestPrometheusJmxExporter()
+		test.update(prometheusJmxExporter)
+
+		err := validatePrometheusJmxExporter(prometheusJmxExporter)
+
+		if len(test.expected) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected error '%s', got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+// newTestAdmissionRequest returns an admission request of operation on prometheusJmxExporter
+func newTestAdmissionRequest(t *testing.T, operation v1beta1.Operation, prometheusJmxExporter, old *v1alpha1.PrometheusJmxExporter) *v1beta1.AdmissionRequest {
+	req := &v1beta1.AdmissionRequest{Operation: operation, Namespace: "default"}
+
+	req.Object.Raw = encodeTestPrometheusJmxExporter(t, prometheusJmxExporter)
+	if old != nil {
+		req.OldObject.Raw = encodeTestPrometheusJmxExporter(t, old)
+	}
+
+	return req
+}
+
+// encodeTestPrometheusJmxExporter returns prometheusJmxExporter encoded as the API server sends it to the webhook
+func encodeTestPrometheusJmxExporter(t *testing.T, prometheusJmxExporter *v1alpha1.PrometheusJmxExporter) []byte {
+	data, err := json.Marshal(prometheusJmxExporter)
+	if err != nil {
+		t.Fatalf("encoding prometheusjmxexporter failed: %v", err)
+	}
+
+	return data
+}
+
+func TestValidatePrometheusJmxExporterRequest(t *testing.T) {
+	installFakeAPI()
+	installFakeCache(t)
+
+	valid := newTestPrometheusJmxExporter()
+	valid.Spec.Config.Inline = testConfig
+
+	invalid := newTestPrometheusJmxExporter()
+	invalid.Spec.Config.Inline = &v1alpha1.PrometheusJmxExporterConfig{JmxUrl: stringPtr("service:jmx:rmi:///jndi/rmi://localhost:5555/jmxrmi")}
+
+	if response := validatePrometheusJmxExporterRequest(newTestAdmissionRequest(t, v1beta1.Create, valid, nil)); !response.Allowed {
+		t.Errorf("expected valid inline config admitted, got %v", response.Result)
+	}
+
+	response := validatePrometheusJmxExporterRequest(newTestAdmissionRequest(t, v1beta1.Create, invalid, nil))
+	if response.Allowed {
+		t.Error("expected invalid inline config rejected")
+	} else if !strings.Contains(response.Result.Message, "jmxUrl must not be set") {
+		t.Errorf("unexpected rejection message '%s'", response.Result.Message)
+	}
+
+	if response := validatePrometheusJmxExporterRequest(newTestAdmissionRequest(t, v1beta1.Update, invalid, valid)); response.Allowed {
+		t.Error("expected spec changed to invalid inline config rejected")
+	}
+
+	// the operator updating the status or the finalizers of an exporter which has become invalid is not blocked
+	if response := validatePrometheusJmxExporterRequest(newTestAdmissionRequest(t, v1beta1.Update, invalid, invalid)); !response.Allowed {
+		t.Errorf("expected update of unchanged spec admitted, got %v", response.Result)
+	}
+}
@@ -0,0 +1,221 @@
+package stub
+
+import (
+	"encoding/json"
+	"github.com/banzaicloud/prometheus-jmx-exporter-operator/pkg/apis/banzaicloud/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"reflect"
+)
+
+// WebhookServer serves the admission webhooks of the operator: a mutating webhook which injects the
+// prometheus jmx exporter agent into the pods selected by PrometheusJmxExporters in startup mode and
+// a validating webhook which rejects invalid PrometheusJmxExporters
+type WebhookServer struct {
+	addr          string
+	certFile      string
+	keyFile       string
+	injectorImage string
+}
+
+// NewWebhookServer creates a WebhookServer listening on addr. injectorImage is the image of
+// the init container which provides the prometheus jmx exporter jars.
+func NewWebhookServer(addr, certFile, keyFile, injectorImage string) *WebhookServer {
+	return &WebhookServer{
+		addr:          addr,
+		certFile:      certFile,
+		keyFile:       keyFile,
+		injectorImage: injectorImage,
+	}
+}
+
+// Run starts serving admission requests over TLS, it blocks until the server fails
+func (s *WebhookServer) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate-pods", func(w http.ResponseWriter, r *http.Request) {
+		serveAdmissionReview(w, r, s.mutatePod)
+	})
+	mux.HandleFunc("/validate-prometheusjmxexporters", func(w http.ResponseWriter, r *http.Request) {
+		serveAdmissionReview(w, r, validatePrometheusJmxExporterRequest)
+	})
+
+	server := &http.Server{
+		Addr:    s.addr,
+		Handler: mux,
+	}
+
+	logrus.Infof("Starting webhook server on '%s'", s.addr)
+
+	return server.ListenAndServeTLS(s.certFile, s.keyFile)
+}
+
+// serveAdmissionReview decodes the admission review of the request and responds with the decision of admit
+func serveAdmissionReview(w http.ResponseWriter, r *http.Request, admit func(*v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		logrus.Errorf("Reading admission review failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := v1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		logrus.Errorf("Decoding admission review failed: %v", err)
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	response := admit(review.Request)
+	response.UID = review.Request.UID
+
+	review.Response = response
+	review.Request = nil
+
+	resp, err := json.Marshal(review)
+	if err != nil {
+		logrus.Errorf("Encoding admission review failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(resp); err != nil {
+		logrus.Errorf("Writing admission review response failed: %v", err)
+	}
+}
+
+// mutatePod returns the admission response with the patch that injects the prometheus jmx exporter agent
+// into the pod of the request. Pods are always admitted, failing to inject the agent must not prevent
+// the application from starting.
+func (s *WebhookServer) mutatePod(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	allowed := &v1beta1.AdmissionResponse{Allowed: true}
+
+	pod := v1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		logrus.Errorf("Decoding pod failed: %v", err)
+		return &v1beta1.AdmissionResponse{
+			Allowed: true,
+			Result:  &metav1.Status{Message: err.Error()},
+		}
+	}
+
+	// the name and namespace of the pod may not be set yet
+	pod.Namespace = req.Namespace
+	podName := pod.Name
+	if len(podName) == 0 {
+		podName = pod.GenerateName
+	}
+
+	prometheusJmxExporters, err := queryPodPrometheusJmxExporters(&pod)
+	if err != nil {
+		logrus.Errorf("Error during querying prometheusjmxexporters in namespace '%s': %v", req.Namespace, err)
+		return allowed
+	}
+
+	prometheusJmxExporter, err := findExporterForPod(prometheusJmxExporters, &pod)
+	if err != nil || prometheusJmxExporter == nil || !prometheusJmxExporter.Spec.IsStartupMode() {
+		return allowed
+	}
+
+	logrus.Infof("Injecting prometheus jmx exporter agent into pod '%s/%s'", req.Namespace, podName)
+
+	config, err := loadConfig(prometheusJmxExporter)
+	if err != nil {
+		logrus.Errorf("Error during retrieving prometheus jmx exporter config: %v", err)
+		return allowed
+	}
+
+	secret, err := newConfigSecret(prometheusJmxExporter, config)
+	if err != nil {
+		logrus.Errorf("Error during rendering prometheus jmx exporter config: %v", err)
+		return allowed
+	}
+
+	patch, err := createInjectionPatch(&pod, config, &prometheusJmxExporter.Spec, secret.Name, s.injectorImage)
+	if err != nil {
+		logrus.Warnf("Injecting prometheus jmx exporter agent into pod '%s/%s' failed: %v", req.Namespace, podName, err)
+		return allowed
+	}
+
+	if len(patch) == 0 {
+		return allowed
+	}
+
+	// the secret the init container copies the config from is kept up to date with the config the pod is injected with
+	if err := applyConfigSecret(secret); err != nil {
+		logrus.Errorf("Storing prometheus jmx exporter config in secret '%s/%s' failed: %v", secret.Namespace, secret.Name, err)
+		return allowed
+	}
+
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		logrus.Errorf("Encoding patch failed: %v", err)
+		return allowed
+	}
+
+	logrus.Debugf("Patch for pod '%s/%s': %s", req.Namespace, podName, string(patchData))
+
+	patchType := v1beta1.PatchTypeJSONPatch
+
+	return &v1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patchData,
+		PatchType: &patchType,
+	}
+}
+
+// validatePrometheusJmxExporterRequest returns the admission response which rejects the PrometheusJmxExporter
+// of the request if it's invalid
+func validatePrometheusJmxExporterRequest(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if req.Operation == v1beta1.Delete {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	prometheusJmxExporter := v1alpha1.PrometheusJmxExporter{}
+	if err := json.Unmarshal(req.Object.Raw, &prometheusJmxExporter); err != nil {
+		logrus.Errorf("Decoding prometheusjmxexporter failed: %v", err)
+		return denied(err)
+	}
+
+	if len(prometheusJmxExporter.Namespace) == 0 {
+		prometheusJmxExporter.Namespace = req.Namespace
+	}
+
+	if prometheusJmxExporter.DeletionTimestamp != nil {
+		// removing the finalizer of a prometheusJmxExporter being deleted must not be blocked
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	if req.Operation == v1beta1.Update {
+		// the operator updates the status and the finalizers which must not be blocked
+		// by the spec becoming invalid in the meantime, e.g. the referenced configMap changed
+		oldPrometheusJmxExporter := v1alpha1.PrometheusJmxExporter{}
+		if err := json.Unmarshal(req.OldObject.Raw, &oldPrometheusJmxExporter); err == nil &&
+			reflect.DeepEqual(oldPrometheusJmxExporter.Spec, prometheusJmxExporter.Spec) {
+			return &v1beta1.AdmissionResponse{Allowed: true}
+		}
+	}
+
+	if err := validatePrometheusJmxExporter(&prometheusJmxExporter); err != nil {
+		logrus.Infof("Rejecting prometheusjmxexporter '%s/%s': %v", prometheusJmxExporter.Namespace, prometheusJmxExporter.Name, err)
+		return denied(err)
+	}
+
+	return &v1beta1.AdmissionResponse{Allowed: true}
+}
+
+// denied returns the admission response which rejects the request because of err
+func denied(err error) *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: err.Error(),
+		},
+	}
+}
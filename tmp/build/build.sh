@@ -16,3 +16,7 @@ REPO_PATH="github.com/banzaicloud/prometheus-jmx-exporter-operator"
 BUILD_PATH="${REPO_PATH}/cmd/${PROJECT_NAME}"
 echo "building "${PROJECT_NAME}"..."
 GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ${BIN_DIR}/${PROJECT_NAME} $BUILD_PATH
+
+ATTACHER_NAME="jmx-exporter-attach"
+echo "building "${ATTACHER_NAME}"..."
+GOOS=linux GOARCH=amd64 CGO_ENABLED=0 go build -o ${BIN_DIR}/${ATTACHER_NAME} ${REPO_PATH}/cmd/${ATTACHER_NAME}